@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -14,11 +15,34 @@ import (
 )
 
 type Config struct {
-	Listen   string        `env:"LISTEN"   validate:"required"`
-	Limit    int           `env:"LIMIT"    validate:"min=4000,max=20000000000"`
-	Timeout  time.Duration `env:"TIMEOUT"  validate:"min=0s,max=120m"`
-	Dir      string        `env:"DIR"      validate:"required"`
-	Storages []string      `env:"STORAGES" validate:"required"`
+	Listen    string        `env:"LISTEN"                        validate:"required"`
+	Limit     int           `env:"LIMIT"                         validate:"min=4000,max=20000000000"`
+	Timeout   time.Duration `env:"TIMEOUT"                       validate:"min=0s,max=120m"`
+	Dir       string        `env:"DIR"                           validate:"required"`
+	Storages  []string      `env:"STORAGES"                      validate:"required_without=File"`
+	File      string        `env:"STORAGES_FILE"                 validate:"omitempty,file"`
+	Watch     time.Duration `env:"WATCH, default=10s"            validate:"min=1s,max=24h"`
+	Replicas  int           `env:"REPLICAS, default=1"           validate:"min=1"`
+	Quorum    int           `env:"QUORUM, default=1"             validate:"min=1"`
+	Chunk     int           `env:"CHUNK_SIZE, default=67108864"  validate:"min=65536"`
+	Count     int           `env:"PART_COUNT, default=0"         validate:"min=0,max=65535"`
+	Data      int           `env:"DATA_PARTS, default=4"         validate:"min=1,max=128"`
+	Parity    int           `env:"PARITY_PARTS, default=0"       validate:"min=0,max=128"`
+	Stream    bool          `env:"STREAM_UPLOAD, default=false"  validate:"-"`
+	Retries   int           `env:"RETRIES, default=3"            validate:"min=1,max=20"`
+	RetryBase time.Duration `env:"RETRY_BASE, default=100ms"     validate:"min=0s,max=1m"`
+	RetryMax  time.Duration `env:"RETRY_MAX, default=2s"         validate:"min=0s,max=10m"`
+	Hedge     time.Duration `env:"HEDGE, default=0s"             validate:"min=0s,max=1m"`
+	Window    int           `env:"FETCH_WINDOW, default=4"       validate:"min=1,max=64"`
+	Failures  int           `env:"BREAKER_FAILURES, default=5"   validate:"min=0"`
+	Cooldown  time.Duration `env:"BREAKER_COOLDOWN, default=10s" validate:"min=0s,max=1h"`
+	Eject     bool          `env:"EJECT, default=false"          validate:"-"`
+	Health    time.Duration `env:"HEALTH_INTERVAL, default=5s"   validate:"min=0s,max=1h"`
+	Rate      int           `env:"REBALANCE_RATE, default=0"     validate:"min=0"`
+	Expiry    time.Duration `env:"SESSION_EXPIRY, default=24h"   validate:"min=1s,max=720h"`
+	Sweep     time.Duration `env:"SESSION_SWEEP, default=1m"     validate:"min=1s,max=24h"`
+	Trace     string        `env:"TRACE"                         validate:"-"`
+	Token     string        `env:"ADMIN_TOKEN"                   validate:"-"`
 }
 
 func NewConfig() (c Config, e error) {
@@ -35,5 +59,70 @@ func NewConfig() (c Config, e error) {
 		return Config{}, fmt.Errorf("invalid config: %w", validation.Pretty(err))
 	}
 
+	if c.File != "" {
+		storages, err := ReadStorages(c.File)
+		if err != nil {
+			return Config{}, fmt.Errorf("read storages: %w", err)
+		}
+		c.Storages = storages
+	}
+
+	if err := c.check(c.Storages); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+
 	return c, nil
 }
+
+// check relates the settings to each other and to the storages, which the
+// tags cannot, so a misconfiguration stops the balancer instead of failing
+// every upload on quorum.
+func (c Config) check(storages []string) error {
+	if c.Quorum > c.Replicas {
+		return fmt.Errorf("QUORUM %d exceeds REPLICAS %d", c.Quorum, c.Replicas)
+	}
+	if c.Replicas > len(storages) {
+		return fmt.Errorf("REPLICAS %d exceeds the %d storages", c.Replicas, len(storages))
+	}
+	if c.Parity > 0 && c.Data+c.Parity > len(storages) {
+		return fmt.Errorf("DATA_PARTS %d and PARITY_PARTS %d exceed the %d storages", c.Data, c.Parity, len(storages))
+	}
+
+	return nil
+}
+
+// least is the fewest storages check lets through, the membership refuses
+// removals below it.
+func (c Config) least() int {
+	if c.Parity > 0 {
+		return max(c.Replicas, c.Data+c.Parity)
+	}
+	return c.Replicas
+}
+
+// ReadStorages parses storage addresses separated by commas or new lines,
+// lines starting with # are skipped.
+func ReadStorages(path string) ([]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+
+	storages := make([]string, 0)
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, storage := range strings.Split(line, ",") {
+			if storage = strings.TrimSpace(storage); storage != "" {
+				storages = append(storages, storage)
+			}
+		}
+	}
+	if len(storages) == 0 {
+		return nil, errors.New("no storages found")
+	}
+
+	return storages, nil
+}
@@ -4,9 +4,16 @@ import (
 	"balancer/internal/controller"
 	"balancer/internal/repository"
 	"balancer/internal/service"
+	"balancer/pkg/conc"
 	"balancer/pkg/graceful"
 	"balancer/pkg/logger"
+	"balancer/pkg/metrics"
+	"balancer/pkg/reload"
+	"balancer/pkg/retry"
+	"balancer/pkg/trace"
+	"balancer/pkg/web"
 	"log/slog"
+	"os"
 )
 
 func main() {
@@ -15,10 +22,51 @@ func main() {
 	conf, err := NewConfig()
 	graceful.Check(err)
 
+	// The healthcheck probes readiness unless another path is given
+	if len(os.Args) > 1 && os.Args[1] == "probe" {
+		path := "/readyz"
+		if len(os.Args) > 2 {
+			path = os.Args[2]
+		}
+		graceful.Check(web.Probe(conf.Listen, path, conf.Timeout))
+		return
+	}
+
+	if conf.Trace != "" {
+		exporter, err := trace.Open(conf.Trace, "balancer")
+		graceful.Check(err)
+		trace.SetExporter(exporter)
+		graceful.Add(exporter.Close)
+	}
+
 	file := repository.NewFile(conf.Dir)
-	storage := repository.NewStorage(conf.Timeout, conf.Storages)
+	storage := repository.NewStorage(
+		conf.Timeout, conf.Storages, conf.Replicas,
+		retry.Backoff{Attempts: conf.Retries, Base: conf.RetryBase, Max: conf.RetryMax},
+		conf.Failures, conf.Cooldown, conf.Eject,
+		metrics.Default,
+	)
+	manifest, err := repository.NewManifest(conf.Dir)
+	graceful.Check(err)
+	session, err := repository.NewSession(conf.Dir)
+	graceful.Check(err)
 	vault := service.NewVault(file)
-	upload := service.NewSplitUpload(file, storage)
+	upload := service.NewSplitUpload(
+		file, storage, manifest,
+		conf.Quorum, conf.Chunk, conf.Count,
+		conf.Data, conf.Parity, conf.Stream,
+	)
+	download := service.NewSplitDownload(storage, manifest, file, conf.Hedge, conf.Window)
+	remove := service.NewSplitDelete(storage, manifest)
+	catalog := service.NewCatalog(manifest)
+	membership := service.NewMembership(storage, conf.Quorum, conf.least())
+	keylock := conc.NewKeyRWLock()
+	rebalancer := service.NewRebalancer(
+		storage, manifest, membership,
+		keylock, conf.Rate,
+	)
+	jobs := service.NewJobs()
+	sessions := service.NewSessions(session, conf.Expiry)
 
 	external, err := controller.NewBalancer(
 		conf.Listen,
@@ -26,10 +74,46 @@ func main() {
 		conf.Timeout,
 		vault,
 		upload,
+		download,
+		remove,
+		catalog,
+		membership,
+		rebalancer,
+		jobs,
+		sessions,
+		keylock,
+		conf.Token,
 	)
 	graceful.Check(err)
 	graceful.Add(external.Close)
 
+	if conf.Health > 0 {
+		health := service.WatchHealth(storage, conf.Health)
+		graceful.Add(health.Close)
+	}
+
+	sweeper := service.Sweep(sessions, conf.Sweep)
+	graceful.Add(sweeper.Close)
+
+	if conf.File != "" {
+		watcher := reload.Watch(conf.File, conf.Watch, func() error {
+			storages, err := ReadStorages(conf.File)
+			if err != nil {
+				return err
+			}
+			if err := conf.check(storages); err != nil {
+				return err
+			}
+			_, err = membership.Sync(storages)
+			return err
+		})
+		graceful.Add(watcher.Close)
+	}
+
+	if conf.Token == "" {
+		slog.Warn("admin routes are closed without ADMIN_TOKEN")
+	}
+
 	slog.Info("started", "listen", conf.Listen)
 	graceful.Wait()
 }
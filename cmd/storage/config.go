@@ -14,10 +14,12 @@ import (
 )
 
 type Config struct {
-	Listen  string        `env:"LISTEN"   validate:"required"`
-	Limit   int           `env:"LIMIT"    validate:"min=4000,max=20000000000"`
-	Timeout time.Duration `env:"TIMEOUT"  validate:"min=0s,max=120m"`
-	Dir     string        `env:"DIR"      validate:"required"`
+	Listen  string        `env:"LISTEN"                     validate:"required"`
+	Limit   int           `env:"LIMIT"                      validate:"min=4000,max=20000000000"`
+	Timeout time.Duration `env:"TIMEOUT"                    validate:"min=0s,max=120m"`
+	Dir     string        `env:"DIR"                        validate:"required"`
+	Reserve int           `env:"MIN_FREE, default=67108864" validate:"min=0"`
+	Trace   string        `env:"TRACE"                      validate:"-"`
 }
 
 func NewConfig() (c Config, e error) {
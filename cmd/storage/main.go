@@ -6,7 +6,10 @@ import (
 	"balancer/internal/service"
 	"balancer/pkg/graceful"
 	"balancer/pkg/logger"
+	"balancer/pkg/trace"
+	"balancer/pkg/web"
 	"log/slog"
+	"os"
 )
 
 func main() {
@@ -15,14 +18,33 @@ func main() {
 	conf, err := NewConfig()
 	graceful.Check(err)
 
+	// The healthcheck probes readiness unless another path is given
+	if len(os.Args) > 1 && os.Args[1] == "probe" {
+		path := "/readyz"
+		if len(os.Args) > 2 {
+			path = os.Args[2]
+		}
+		graceful.Check(web.Probe(conf.Listen, path, conf.Timeout))
+		return
+	}
+
+	if conf.Trace != "" {
+		exporter, err := trace.Open(conf.Trace, "storage")
+		graceful.Check(err)
+		trace.SetExporter(exporter)
+		graceful.Add(exporter.Close)
+	}
+
 	file := repository.NewFile(conf.Dir)
-	vault := service.NewVault(file)
+	index, err := repository.NewIndex(conf.Dir)
+	graceful.Check(err)
+	shelf := service.NewShelf(file, index, conf.Reserve)
 
 	external, err := controller.NewStorage(
 		conf.Listen,
 		conf.Limit,
 		conf.Timeout,
-		vault,
+		shelf,
 	)
 	graceful.Check(err)
 	graceful.Add(external.Close)
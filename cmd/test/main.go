@@ -21,6 +21,7 @@ func main() {
 	file := repository.NewFile(*dir)
 	balancer := repository.NewBalancer(*addr, *timeout)
 	upload := service.NewPlainUpload(file, balancer)
+	download := service.NewPlainDownload(file, balancer)
 
 	switch *mode {
 	case "upload":
@@ -28,6 +29,9 @@ func main() {
 			slog.Error("upload", "error", err)
 		}
 	case "download":
+		if err := download.Download(*name); err != nil {
+			slog.Error("download", "error", err)
+		}
 	default:
 		flag.PrintDefaults()
 	}
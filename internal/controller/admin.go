@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"balancer/internal/service"
+	"balancer/pkg/web"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type storagesBody struct {
+	Storages []string `json:"storages"`
+}
+
+// admin lets the request through only with the configured bearer token,
+// without a token configured the admin routes are closed.
+func (e *Balancer) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if e.token == "" || !ok || subtle.ConstantTimeCompare([]byte(given), []byte(e.token)) != 1 {
+			slog.Error("unauthorized admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+func (e *Balancer) ListStorages(w http.ResponseWriter, r *http.Request) {
+	e.respondStorages(w, e.membership.List())
+}
+
+func (e *Balancer) AddStorages(w http.ResponseWriter, r *http.Request) {
+	backends, err := decodeStorages(r)
+	if err != nil {
+		slog.Error("invalid storages", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	e.respondStorages(w, e.membership.Add(backends))
+}
+
+func (e *Balancer) RemoveStorages(w http.ResponseWriter, r *http.Request) {
+	backends, err := decodeStorages(r)
+	if err != nil {
+		slog.Error("invalid storages", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	current, err := e.membership.Remove(backends)
+	if errors.Is(err, service.ErrTooFew) {
+		slog.Error("remove storages", "error", err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	e.respondStorages(w, current)
+}
+
+func (e *Balancer) Health(w http.ResponseWriter, r *http.Request) {
+	if err := web.JSON(w, http.StatusOK, e.membership.Health()); err != nil {
+		slog.Error("respond", "error", err)
+	}
+}
+
+func (e *Balancer) respondStorages(w http.ResponseWriter, backends []string) {
+	if err := web.JSON(w, http.StatusOK, storagesBody{Storages: backends}); err != nil {
+		slog.Error("respond", "error", err)
+	}
+}
+
+func decodeStorages(r *http.Request) ([]string, error) {
+	var body storagesBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	if len(body.Storages) == 0 {
+		return nil, errors.New("no storages given")
+	}
+	for _, backend := range body.Storages {
+		if _, _, err := net.SplitHostPort(backend); err != nil {
+			return nil, fmt.Errorf("storage %q: %w", backend, err)
+		}
+	}
+
+	return body.Storages, nil
+}
+
+func (e *Balancer) Rebalance(w http.ResponseWriter, r *http.Request) {
+	dry, err := query(r, "dry")
+	if err != nil {
+		slog.Error("invalid dry", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if dry {
+		report, err := e.rebalancer.Plan()
+		if err != nil {
+			slog.Error("plan rebalance", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		e.respondRebalance(w, http.StatusOK, report)
+		return
+	}
+
+	report, err := e.rebalancer.Start()
+	if errors.Is(err, service.ErrRebalancing) {
+		slog.Error("start rebalance", "error", err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Error("start rebalance", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/admin/rebalance")
+	e.respondRebalance(w, http.StatusAccepted, report)
+}
+
+func (e *Balancer) RebalanceStatus(w http.ResponseWriter, r *http.Request) {
+	report, err := e.rebalancer.Status()
+	if errors.Is(err, fs.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("rebalance status", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	e.respondRebalance(w, http.StatusOK, report)
+}
+
+func (e *Balancer) respondRebalance(w http.ResponseWriter, code int, report service.RebalanceReport) {
+	if err := web.JSON(w, code, report); err != nil {
+		slog.Error("respond", "error", err)
+	}
+}
@@ -4,20 +4,42 @@ import (
 	"balancer/internal/service"
 	"balancer/pkg/conc"
 	"balancer/pkg/data"
+	"balancer/pkg/metrics"
 	"balancer/pkg/str"
+	"balancer/pkg/trace"
 	"balancer/pkg/web"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
 type Balancer struct {
-	server  *web.Server
-	vault   *service.Vault
-	upload  *service.SplitUpload
-	keylock *conc.KeyLock
+	server     *web.Server
+	vault      *service.Vault
+	upload     *service.SplitUpload
+	download   *service.SplitDownload
+	remove     *service.SplitDelete
+	catalog    *service.Catalog
+	membership *service.Membership
+	rebalancer *service.Rebalancer
+	jobs       *service.Jobs
+	sessions   *service.Sessions
+	keylock    *conc.KeyRWLock
+	token      string
 }
 
 func NewBalancer(
@@ -26,18 +48,55 @@ func NewBalancer(
 	timeout time.Duration,
 	vault *service.Vault,
 	upload *service.SplitUpload,
+	download *service.SplitDownload,
+	remove *service.SplitDelete,
+	catalog *service.Catalog,
+	membership *service.Membership,
+	rebalancer *service.Rebalancer,
+	jobs *service.Jobs,
+	sessions *service.Sessions,
+	keylock *conc.KeyRWLock,
+	token string,
 ) (*Balancer, error) {
 	e := &Balancer{
-		vault:   vault,
-		upload:  upload,
-		keylock: conc.NewKeyLock(),
+		vault:      vault,
+		upload:     upload,
+		download:   download,
+		remove:     remove,
+		catalog:    catalog,
+		membership: membership,
+		rebalancer: rebalancer,
+		jobs:       jobs,
+		sessions:   sessions,
+		keylock:    keylock,
+		token:      token,
 	}
 
 	m := http.NewServeMux()
+	m.HandleFunc("GET /files", e.List)
+	m.HandleFunc("POST /files", e.Upload)
 	m.HandleFunc("POST /files/{name}", e.Upload)
 	m.HandleFunc("GET /files/{name}", e.Download)
+	m.HandleFunc("HEAD /files/{name}", e.Stat)
+	m.HandleFunc("DELETE /files/{name}", e.Delete)
+	m.HandleFunc("GET /uploads/{id}", e.Status)
+	m.HandleFunc("POST /files/{name}/sessions", e.CreateSession)
+	m.HandleFunc("HEAD /sessions/{id}", e.SessionOffset)
+	m.HandleFunc("GET /sessions/{id}", e.SessionStatus)
+	m.HandleFunc("PATCH /sessions/{id}", e.AppendSession)
+	m.HandleFunc("DELETE /sessions/{id}", e.DeleteSession)
+	m.HandleFunc("GET /admin/storages", e.admin(e.ListStorages))
+	m.HandleFunc("POST /admin/storages", e.admin(e.AddStorages))
+	m.HandleFunc("DELETE /admin/storages", e.admin(e.RemoveStorages))
+	m.HandleFunc("GET /healthz", live)
+	m.HandleFunc("GET /readyz", e.Ready)
+	m.HandleFunc("GET /admin/health", e.admin(e.Health))
+	m.HandleFunc("GET /admin/rebalance", e.admin(e.RebalanceStatus))
+	m.HandleFunc("POST /admin/rebalance", e.admin(e.Rebalance))
+	m.Handle("GET /metrics", metrics.Default)
+	e.collect(metrics.Default)
 
-	server, err := web.NewServer(m, addr, limit, timeout)
+	server, err := web.NewServer(trace.Wrap(metrics.NewHTTP(metrics.Default).Wrap(m)), addr, limit, timeout)
 	if err != nil {
 		return nil, fmt.Errorf("serve: %w", err)
 	}
@@ -47,6 +106,17 @@ func NewBalancer(
 }
 
 func (e *Balancer) Upload(w http.ResponseWriter, r *http.Request) {
+	wait, err := query(r, "wait")
+	if err != nil {
+		slog.Error("invalid wait format", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if multipartForm(r) {
+		e.form(w, r, wait)
+		return
+	}
+
 	name := r.PathValue("name")
 	if !str.Filename.MatchString(name) {
 		slog.Error("invalid name format", "name", name)
@@ -60,37 +130,356 @@ func (e *Balancer) Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.ContentLength >= 0 && e.upload.Streams(name) {
+		e.stream(w, r, name, digest, wait)
+		return
+	}
+
+	e.accept(w, r, name, digest, wait, r.Body, int(r.ContentLength))
+}
+
+// stream sends the body to storages while it is received, the client waits
+// for the distribution like with wait. The name is checked again under its
+// lock, a file recorded meanwhile is uploaded through the vault instead, so
+// a failed stream never deletes the parts of a recorded file.
+func (e *Balancer) stream(w http.ResponseWriter, r *http.Request, name, digest string, wait bool) {
 	e.keylock.Lock(name)
 	e.keylock.Lock(digest)
+	if !e.upload.Streams(name) {
+		e.keylock.Unlock(name)
+		e.keylock.Unlock(digest)
+		e.accept(w, r, name, digest, wait, r.Body, int(r.ContentLength))
+		return
+	}
+	defer e.keylock.Unlock(name)
+	defer e.keylock.Unlock(digest)
+
+	job := e.jobs.Create(name)
+	reader := data.NewProgressReader(r.Body, int(r.ContentLength), data.SlogProgress(name))
+	err := e.upload.Stream(context.WithoutCancel(r.Context()), name, digest, reader, int(r.ContentLength), job)
+	job.Finish(err)
 
-	reader := data.NewProgressReader(
-		r.Body, int(r.ContentLength),
-		data.SlogProgress(name),
-	)
-	hash, size, err := e.vault.Write(reader, name)
+	code := http.StatusCreated
+	switch {
+	case errors.Is(err, service.ErrDigest) || errors.Is(err, io.ErrUnexpectedEOF):
+		slog.Error("corrupted data", "name", name, "error", err)
+		code = http.StatusBadRequest
+	case err != nil:
+		slog.Error("upload", "name", name, "error", err)
+		code = http.StatusBadGateway
+	default:
+		w.Header().Set("Location", "/files/"+name)
+	}
+
+	if err := web.JSON(w, code, job.Status()); err != nil {
+		slog.Error("respond", "name", name, "error", err)
+	}
+}
+
+// accept hashes the body to a temp file, checks it against the digest and
+// distributes it. An error is returned when the body was not hashed, then
+// the response is already written.
+func (e *Balancer) accept(
+	w http.ResponseWriter,
+	r *http.Request,
+	name, digest string,
+	wait bool,
+	body io.Reader,
+	length int,
+) error {
+	job, hash, size, unlock, err := e.receive(r.Context(), name, digest, body, length)
+	if errors.Is(err, service.ErrDigest) {
+		slog.Error("corrupted data", "name", name, "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
 	if err != nil {
-		slog.Error("upload", "name", name)
+		slog.Error("upload", "name", name, "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return err
 	}
-	if hash != digest {
-		slog.Error("corrupted data", "hash", hash, "digest", digest)
-		w.WriteHeader(http.StatusBadRequest)
+
+	// The distribution outlives the request unless the client waits, and is
+	// not aborted when the client goes away in either case
+	ctx := context.WithoutCancel(r.Context())
+	if wait {
+		defer unlock()
+		e.distribute(ctx, w, name, hash, size, job)
+		return nil
+	}
+	e.dispatch(ctx, name, hash, size, job, unlock)
+
+	w.Header().Set("Location", "/uploads/"+job.ID())
+	if err := web.JSON(w, http.StatusAccepted, job.Status()); err != nil {
+		slog.Error("respond", "name", name, "error", err)
+	}
+	return nil
+}
+
+// receive hashes the body to the vault and checks it against the digest,
+// the name and hash stay locked until unlock is called. An empty digest
+// takes whatever the body hashes to. On error the job is finished and the
+// locks are released already.
+func (e *Balancer) receive(
+	ctx context.Context,
+	name, digest string,
+	body io.Reader,
+	length int,
+) (job *service.Job, hash string, size int, unlock func(), err error) {
+	locked := digest
+	e.keylock.Lock(name)
+	if locked != "" {
+		e.keylock.Lock(locked)
+	}
+	unlock = func() {
+		e.keylock.Unlock(name)
+		if locked != "" {
+			e.keylock.Unlock(locked)
+		}
+	}
+
+	job = e.jobs.Create(name)
+	reader := data.NewProgressReader(body, length, data.SlogProgress(name))
+	_, span := trace.Start(ctx, "hash")
+	span.Set("name", name)
+	if digest != "" {
+		hash, size, err = e.vault.Write(reader, name)
+	} else {
+		// Another upload of the same content may hold the hash until its
+		// distribution is done, it is only waited for once hashed
+		hash, size, err = e.vault.Claim(reader, func(hash string) {
+			locked = hash
+			e.keylock.Lock(locked)
+		})
+	}
+	span.Set("size", size)
+	span.Fail(err)
+	span.Finish()
+
+	if err == nil && hash != digest && digest != "" {
+		err = fmt.Errorf("hash %s, digest %s: %w", hash, digest, service.ErrDigest)
 		e.vault.Remove(hash)
-		return
 	}
+	if err != nil {
+		job.Finish(err)
+		unlock()
+		return job, "", 0, nil, err
+	}
+
+	job.Hashed(hash)
+	return job, hash, size, unlock, nil
+}
 
+// dispatch distributes the hashed file in the background and releases the
+// locks once it is done.
+func (e *Balancer) dispatch(
+	ctx context.Context,
+	name, hash string,
+	size int,
+	job *service.Job,
+	unlock func(),
+) {
 	go func() {
-		e.upload.Upload(name, hash, size)
-		e.keylock.Unlock(name)
-		e.keylock.Unlock(digest)
+		defer unlock()
+		err := e.upload.Upload(ctx, name, hash, size, job)
+		if err != nil {
+			slog.Error("upload", "name", name, "hash", hash, "error", err)
+		}
+		job.Finish(err)
 	}()
 }
 
+// distribute uploads parts while the client waits and responds with the job
+// status, so the caller knows which parts were not acknowledged.
+func (e *Balancer) distribute(
+	ctx context.Context,
+	w http.ResponseWriter,
+	name, hash string,
+	size int,
+	job *service.Job,
+) {
+	err := e.upload.Upload(ctx, name, hash, size, job)
+	job.Finish(err)
+
+	code := http.StatusCreated
+	if err != nil {
+		slog.Error("upload", "name", name, "hash", hash, "error", err)
+		code = http.StatusBadGateway
+	} else {
+		w.Header().Set("Location", "/files/"+name)
+	}
+
+	if err := web.JSON(w, code, job.Status()); err != nil {
+		slog.Error("respond", "name", name, "error", err)
+	}
+}
+
+func (e *Balancer) Status(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	status, err := e.jobs.Get(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Error("job not found", "id", id)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := web.JSON(w, http.StatusOK, status); err != nil {
+		slog.Error("respond", "id", id, "error", err)
+	}
+}
+
 func (e *Balancer) Download(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !str.Filename.MatchString(name) {
+		slog.Error("invalid name format", "name", name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	e.keylock.RLock(name)
+	defer e.keylock.RUnlock(name)
 
+	if r.Header.Get("Range") != "" && e.ranges(w, r, name) {
+		return
+	}
+
+	reader, manifest, err := e.download.Download(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Error("file not found", "name", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("download", "name", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if manifest.Digest != "" {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", etag(manifest))
+		w.Header().Set("Digest", manifest.Digest)
+		w.Header().Set("Content-Length", strconv.Itoa(manifest.Size))
+		if _, err := io.Copy(w, reader); err != nil {
+			slog.Error("download", "name", name, "error", err)
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+
+	w.Header().Set("Trailer", "Digest")
+	hasher := sha256.New()
+	if _, err := io.Copy(w, io.TeeReader(reader, hasher)); err != nil {
+		slog.Error("download", "name", name, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Set("Digest", hex.EncodeToString(hasher.Sum(nil)))
+}
+
+func (e *Balancer) List(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	after := r.URL.Query().Get("after")
+	limit, err := number(r, "limit", defaultLimit)
+	if err != nil || limit < 1 || limit > maxLimit {
+		slog.Error("invalid limit format", "limit", r.URL.Query().Get("limit"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	page, err := e.catalog.List(prefix, after, limit)
+	if err != nil {
+		slog.Error("list", "prefix", prefix, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := web.JSON(w, http.StatusOK, page); err != nil {
+		slog.Error("respond", "prefix", prefix, "error", err)
+	}
+}
+
+func (e *Balancer) Stat(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !str.Filename.MatchString(name) {
+		slog.Error("invalid name format", "name", name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	e.keylock.RLock(name)
+	defer e.keylock.RUnlock(name)
+
+	manifest, err := e.catalog.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("stat", "name", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	placement := make([]string, len(manifest.Parts))
+	for i, part := range manifest.Parts {
+		placement[i] = fmt.Sprintf("%d=%s", part.Index, strings.Join(part.Backends, ","))
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(manifest.Size))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", etag(manifest))
+	w.Header().Set("Digest", manifest.Digest)
+	w.Header().Set("Last-Modified", manifest.Updated.Format(http.TimeFormat))
+	w.Header().Set("X-Parts", strconv.Itoa(len(manifest.Parts)))
+	w.Header().Set("X-Placement", strings.Join(placement, ";"))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *Balancer) Delete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !str.Filename.MatchString(name) {
+		slog.Error("invalid name format", "name", name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	e.keylock.Lock(name)
+	defer e.keylock.Unlock(name)
+
+	err := e.remove.Delete(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Error("file not found", "name", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("delete", "name", name, "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (e *Balancer) Close(ctx context.Context) error {
 	return e.server.Close(ctx)
 }
+
+func number(r *http.Request, key string, fallback int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(v)
+}
+
+func query(r *http.Request, key string) (bool, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
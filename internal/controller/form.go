@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"balancer/internal/service"
+	"balancer/pkg/str"
+	"balancer/pkg/web"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestField is the form field with the digest of the file part after it.
+const digestField = "digest"
+
+// form uploads every file part of a multipart/form-data body, named by its
+// filename, while the body is read. A digest field applies to the next file
+// only, files without one are taken as they hash. A file not matching its
+// digest fails alone, a malformed part stops the upload of the rest. The
+// response lists the job of every file in the order they were sent.
+func (e *Balancer) form(w http.ResponseWriter, r *http.Request, wait bool) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		slog.Error("invalid form", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// The distributions outlive the request unless the client waits, and
+	// are not aborted when the client goes away in either case
+	ctx := context.WithoutCancel(r.Context())
+	jobs := make([]*service.Job, 0)
+	wg := sync.WaitGroup{}
+	code := http.StatusAccepted
+	fail := func(failure int) {
+		if code == http.StatusAccepted {
+			code = failure
+		}
+	}
+
+	digest := ""
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			slog.Error("invalid form", "error", err)
+			fail(http.StatusBadRequest)
+			break
+		}
+
+		if part.FileName() == "" {
+			if part.FormName() == digestField {
+				digest, err = field(part)
+				if err != nil || !str.Digest.MatchString(digest) {
+					slog.Error("invalid digest format", "digest", digest, "error", err)
+					fail(http.StatusBadRequest)
+					break
+				}
+			}
+			continue
+		}
+
+		name := part.FileName()
+		if !str.Filename.MatchString(name) {
+			slog.Error("invalid name format", "name", name)
+			fail(http.StatusBadRequest)
+			break
+		}
+
+		job, hash, size, unlock, err := e.receive(r.Context(), name, digest, part, 0)
+		digest = ""
+		jobs = append(jobs, job)
+		if errors.Is(err, service.ErrDigest) {
+			slog.Error("corrupted data", "name", name, "error", err)
+			fail(http.StatusBadRequest)
+			continue
+		}
+		if err != nil {
+			slog.Error("upload", "name", name, "error", err)
+			fail(http.StatusInternalServerError)
+			break
+		}
+
+		wg.Add(1)
+		e.dispatch(ctx, name, hash, size, job, func() {
+			unlock()
+			wg.Done()
+		})
+	}
+	if code == http.StatusAccepted && len(jobs) == 0 {
+		slog.Error("invalid form", "error", "no files")
+		code = http.StatusBadRequest
+	}
+
+	if wait {
+		wg.Wait()
+	}
+	statuses := make([]service.JobStatus, len(jobs))
+	for i, job := range jobs {
+		statuses[i] = job.Status()
+		if wait && code == http.StatusAccepted && statuses[i].State == service.JobFailed {
+			code = http.StatusBadGateway
+		}
+	}
+	if wait && code == http.StatusAccepted {
+		code = http.StatusCreated
+	}
+
+	if err := web.JSON(w, code, statuses); err != nil {
+		slog.Error("respond", "error", err)
+	}
+}
+
+// multipartForm tells whether the body is a form with files.
+func multipartForm(r *http.Request) bool {
+	media, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && media == "multipart/form-data"
+}
+
+// field reads a short form value.
+func field(part *multipart.Part) (string, error) {
+	value, err := io.ReadAll(io.LimitReader(part, 256))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(value)), nil
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"balancer/pkg/web"
+	"log/slog"
+	"net/http"
+)
+
+type liveBody struct {
+	Status string `json:"status"`
+}
+
+// live reports that the process serves requests, dependencies are reported
+// by readiness.
+func live(w http.ResponseWriter, r *http.Request) {
+	if err := web.JSON(w, http.StatusOK, liveBody{Status: "ok"}); err != nil {
+		slog.Error("respond", "error", err)
+	}
+}
+
+func (e *Balancer) Ready(w http.ResponseWriter, r *http.Request) {
+	status := e.membership.Status()
+	respondReady(w, status.Ready, status)
+}
+
+func (e *Storage) Ready(w http.ResponseWriter, r *http.Request) {
+	status := e.shelf.Status()
+	respondReady(w, status.Ready, status)
+}
+
+func respondReady(w http.ResponseWriter, ready bool, status any) {
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	if err := web.JSON(w, code, status); err != nil {
+		slog.Error("respond", "error", err)
+	}
+}
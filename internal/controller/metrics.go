@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"balancer/internal/service"
+	"balancer/pkg/disk"
+	"balancer/pkg/metrics"
+	"log/slog"
+)
+
+var jobStates = []service.JobState{
+	service.JobPending, service.JobHashing, service.JobDistributing, service.JobDone, service.JobFailed,
+}
+
+func (e *Balancer) collect(r *metrics.Registry) {
+	r.GaugeFunc("balancer_upload_jobs", "Upload jobs not expired yet by state.", []string{"state"}, func() []metrics.Sample {
+		states := e.jobs.States()
+		samples := make([]metrics.Sample, 0, len(jobStates))
+		for _, state := range jobStates {
+			samples = append(samples, metrics.Sample{Values: []string{string(state)}, Value: float64(states[state])})
+		}
+		return samples
+	})
+	r.GaugeFunc("balancer_keylock_holders", "Name and digest locks held by requests.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(e.keylock.Holders())}}
+	})
+	sizes(r, "balancer_temp", "temp dir", e.vault.Size)
+}
+
+func (e *Storage) collect(r *metrics.Registry) {
+	r.GaugeFunc("storage_disk_free_bytes", "Free bytes on the parts dir disk.", nil, func() []metrics.Sample {
+		status := e.shelf.Status()
+		if status.Total == 0 {
+			return nil
+		}
+		return []metrics.Sample{{Value: float64(status.Free)}}
+	})
+	sizes(r, "storage_parts", "parts dir", e.shelf.Size)
+}
+
+func sizes(r *metrics.Registry, prefix, dir string, size func() (disk.Size, error)) {
+	gauge := func(value func(disk.Size) int) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			s, err := size()
+			if err != nil {
+				slog.Error("measure", "dir", dir, "error", err)
+				return nil
+			}
+			return []metrics.Sample{{Value: float64(value(s))}}
+		}
+	}
+
+	r.GaugeFunc(prefix+"_files", "Files in the "+dir+".", nil, gauge(func(s disk.Size) int { return s.Files }))
+	r.GaugeFunc(prefix+"_bytes", "Bytes in the "+dir+".", nil, gauge(func(s disk.Size) int { return s.Bytes }))
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"balancer/internal/service"
+	"balancer/pkg/web"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+)
+
+// ranges serves the Range of a file with a manifest and tells whether it
+// responded. Files without one, stale If-Range validators and ranges to be
+// ignored are left for the whole file download.
+func (e *Balancer) ranges(w http.ResponseWriter, r *http.Request, name string) bool {
+	manifest, err := e.catalog.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	if err != nil {
+		slog.Error("stat", "name", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	if validator := r.Header.Get("If-Range"); validator != "" && validator != etag(manifest) {
+		return false
+	}
+
+	ranges, err := web.ParseRange(r.Header.Get("Range"), manifest.Size)
+	if errors.Is(err, web.ErrUnsatisfiable) {
+		slog.Error("unsatisfiable range", "name", name, "error", err)
+		w.Header().Set("Content-Range", "bytes */"+strconv.Itoa(manifest.Size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+	if err != nil {
+		slog.Error("ignored range", "name", name, "error", err)
+		return false
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", etag(manifest))
+	w.Header().Set("Digest", manifest.Digest)
+	w.Header().Set("Last-Modified", manifest.Updated.Format(http.TimeFormat))
+	if len(ranges) == 1 {
+		e.single(w, manifest, ranges[0])
+		return true
+	}
+
+	e.multiple(w, manifest, ranges)
+	return true
+}
+
+func (e *Balancer) single(w http.ResponseWriter, manifest service.Manifest, rng web.Range) {
+	reader, err := e.download.Range(manifest, rng.Start, rng.Length)
+	if err != nil {
+		slog.Error("download range", "name", manifest.Name, "range", rng.ContentRange(manifest.Size), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Range", rng.ContentRange(manifest.Size))
+	w.Header().Set("Content-Length", strconv.Itoa(rng.Length))
+	w.WriteHeader(http.StatusPartialContent)
+	if _, err := io.Copy(w, reader); err != nil {
+		slog.Error("download range", "name", manifest.Name, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// multiple writes the ranges as multipart/byteranges, opening each range
+// when it is reached.
+func (e *Balancer) multiple(w http.ResponseWriter, manifest service.Manifest, ranges []web.Range) {
+	body := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+body.Boundary())
+	w.WriteHeader(http.StatusPartialContent)
+
+	for _, rng := range ranges {
+		if err := e.section(body, manifest, rng); err != nil {
+			slog.Error("download range", "name", manifest.Name, "range", rng.ContentRange(manifest.Size), "error", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := body.Close(); err != nil {
+		slog.Error("download range", "name", manifest.Name, "error", err)
+	}
+}
+
+func (e *Balancer) section(body *multipart.Writer, manifest service.Manifest, rng web.Range) error {
+	reader, err := e.download.Range(manifest, rng.Start, rng.Length)
+	if err != nil {
+		return fmt.Errorf("open range: %w", err)
+	}
+	defer reader.Close()
+
+	part, err := body.CreatePart(textproto.MIMEHeader{
+		"Content-Type":  {"application/octet-stream"},
+		"Content-Range": {rng.ContentRange(manifest.Size)},
+	})
+	if err != nil {
+		return fmt.Errorf("create part: %w", err)
+	}
+	if _, err := io.Copy(part, reader); err != nil {
+		return fmt.Errorf("copy range: %w", err)
+	}
+
+	return nil
+}
+
+// etag is the strong validator of the file content.
+func etag(manifest service.Manifest) string {
+	return `"` + manifest.Digest + `"`
+}
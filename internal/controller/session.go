@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"balancer/internal/service"
+	"balancer/pkg/str"
+	"balancer/pkg/web"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const offsetType = "application/offset+octet-stream"
+
+// CreateSession starts a resumable upload of the file, the body is sent in
+// PATCH requests to the session afterwards.
+func (e *Balancer) CreateSession(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !str.Filename.MatchString(name) {
+		slog.Error("invalid name format", "name", name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	digest := r.Header.Get("Digest")
+	if !str.Digest.MatchString(digest) {
+		slog.Error("invalid digest format", "digest", digest)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	length, err := header(r, "Upload-Length")
+	if err != nil {
+		slog.Error("invalid upload length", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	session, err := e.sessions.Create(name, digest, length)
+	if err != nil {
+		slog.Error("create session", "name", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/sessions/"+session.ID)
+	sessionHeaders(w, session)
+	if err := web.JSON(w, http.StatusCreated, session); err != nil {
+		slog.Error("respond", "name", name, "error", err)
+	}
+}
+
+// SessionOffset tells where the upload is resumed from.
+func (e *Balancer) SessionOffset(w http.ResponseWriter, r *http.Request) {
+	session, ok := e.session(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	sessionHeaders(w, session)
+}
+
+func (e *Balancer) SessionStatus(w http.ResponseWriter, r *http.Request) {
+	session, ok := e.session(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := web.JSON(w, http.StatusOK, session); err != nil {
+		slog.Error("respond", "id", session.ID, "error", err)
+	}
+}
+
+// AppendSession writes the body at the Upload-Offset. The request that
+// completes the upload responds like uploading the file at once, an empty
+// request at the length retries that when it failed.
+func (e *Balancer) AppendSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Header.Get("Content-Type") != offsetType {
+		slog.Error("invalid content type", "id", id, "type", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := header(r, "Upload-Offset")
+	if err != nil {
+		slog.Error("invalid upload offset", "id", id, "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	wait, err := query(r, "wait")
+	if err != nil {
+		slog.Error("invalid wait format", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	session, err := e.sessions.Append(id, offset, r.Body)
+	if session.ID != "" {
+		sessionHeaders(w, session)
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		slog.Error("session not found", "id", id)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrOffset):
+		slog.Error("append session", "id", id, "error", err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrLength):
+		slog.Error("append session", "id", id, "error", err)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		slog.Error("append session", "id", id, "offset", session.Offset, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !session.Complete() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	reader, session, err := e.sessions.Open(id)
+	if err != nil {
+		slog.Error("open session", "id", id, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	// The session is kept only when its bytes could not be read, so the
+	// client can retry, a digest mismatch needs a new upload
+	if err := e.accept(w, r, session.Name, session.Digest, wait, reader, session.Length); err != nil {
+		return
+	}
+	if err := e.sessions.Delete(id); err != nil {
+		slog.Error("delete session", "id", id, "error", err)
+	}
+}
+
+func (e *Balancer) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := e.sessions.Delete(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Error("session not found", "id", id)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("delete session", "id", id, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Balancer) session(w http.ResponseWriter, r *http.Request) (service.Session, bool) {
+	id := r.PathValue("id")
+	session, err := e.sessions.Get(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Error("session not found", "id", id)
+		w.WriteHeader(http.StatusNotFound)
+		return service.Session{}, false
+	}
+	if err != nil {
+		slog.Error("get session", "id", id, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return service.Session{}, false
+	}
+
+	return session, true
+}
+
+func sessionHeaders(w http.ResponseWriter, session service.Session) {
+	w.Header().Set("Upload-Offset", strconv.Itoa(session.Offset))
+	w.Header().Set("Upload-Length", strconv.Itoa(session.Length))
+	w.Header().Set("Upload-Expires", session.Expires.Format(http.TimeFormat))
+}
+
+func header(r *http.Request, key string) (int, error) {
+	v, err := strconv.Atoi(r.Header.Get(key))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("%s: negative %d", key, v)
+	}
+	return v, nil
+}
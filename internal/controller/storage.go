@@ -3,33 +3,44 @@ package controller
 import (
 	"balancer/internal/service"
 	"balancer/pkg/data"
+	"balancer/pkg/metrics"
 	"balancer/pkg/str"
+	"balancer/pkg/trace"
 	"balancer/pkg/web"
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 )
 
 type Storage struct {
 	server *web.Server
-	vault  *service.Vault
+	shelf  *service.Shelf
 }
 
 func NewStorage(
 	addr string,
 	limit int,
 	timeout time.Duration,
-	vault *service.Vault,
+	shelf *service.Shelf,
 ) (*Storage, error) {
-	e := &Storage{vault: vault}
+	e := &Storage{shelf: shelf}
 
 	m := http.NewServeMux()
 	m.HandleFunc("POST /parts/{name}", e.Save)
 	m.HandleFunc("GET /parts/{name}", e.Load)
+	m.HandleFunc("DELETE /parts/{name}", e.Delete)
+	m.HandleFunc("GET /health", live)
+	m.HandleFunc("GET /healthz", live)
+	m.HandleFunc("GET /readyz", e.Ready)
+	m.Handle("GET /metrics", metrics.Default)
+	e.collect(metrics.Default)
 
-	server, err := web.NewServer(m, addr, limit, timeout)
+	server, err := web.NewServer(trace.Wrap(metrics.NewHTTP(metrics.Default).Wrap(m)), addr, limit, timeout)
 	if err != nil {
 		return nil, fmt.Errorf("serve: %w", err)
 	}
@@ -46,12 +57,29 @@ func (e *Storage) Save(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	digest := r.Header.Get("Digest")
+	if digest != "" && !str.Digest.MatchString(digest) {
+		slog.Error("invalid digest format", "digest", digest)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	reader := data.NewProgressReader(
 		r.Body, int(r.ContentLength),
 		data.SlogProgress(name),
 	)
 
-	_, _, err := e.vault.Write(reader, name)
+	_, span := trace.Start(r.Context(), "write part")
+	span.Set("name", name)
+	_, size, err := e.shelf.Write(reader, name, digest)
+	span.Set("size", size)
+	span.Fail(err)
+	span.Finish()
+	if errors.Is(err, service.ErrInvalidPart) {
+		slog.Error("invalid part", "name", name, "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		slog.Error("upload", "name", name)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -60,7 +88,52 @@ func (e *Storage) Save(w http.ResponseWriter, r *http.Request) {
 }
 
 func (e *Storage) Load(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !str.Filename.MatchString(name) {
+		slog.Error("invalid name format", "name", name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	reader, hash, err := e.shelf.Read(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Error("part not found", "name", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("download", "name", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", strconv.Quote(hash))
+	http.ServeContent(w, r, name, time.Time{}, reader)
+}
+
+func (e *Storage) Delete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !str.Filename.MatchString(name) {
+		slog.Error("invalid name format", "name", name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err := e.shelf.Remove(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Error("part not found", "name", name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("delete", "name", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (e *Storage) Close(ctx context.Context) error {
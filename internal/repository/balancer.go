@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"time"
 )
@@ -51,3 +52,43 @@ func (s *Balancer) Upload(name, hash string, r io.Reader, limit int) (e error) {
 
 	return nil
 }
+
+// Download returns the file body and a digest getter. The balancer sends the
+// digest as a trailer, so it is only known after the body has been drained.
+func (s *Balancer) Download(name string) (r io.ReadCloser, digest func() string, e error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+
+	url := fmt.Sprintf("http://%s/files/%s", s.base, name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("build request: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("do request: %w", err)
+	}
+
+	if !validation.SuccessStatus(res.StatusCode) {
+		_ = res.Body.Close()
+		cancel()
+		if res.StatusCode == http.StatusNotFound {
+			return nil, nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		}
+		return nil, nil, fmt.Errorf("error code %d", res.StatusCode)
+	}
+
+	reader := data.NewProgressReader(
+		res.Body, int(max(res.ContentLength, 0)),
+		data.SlogProgress(name),
+	)
+	digest = func() string {
+		if v := res.Header.Get("Digest"); v != "" {
+			return v
+		}
+		return res.Trailer.Get("Digest")
+	}
+	return data.NewCancelReadCloser(reader, res.Body, cancel), digest, nil
+}
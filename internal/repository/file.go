@@ -2,6 +2,7 @@ package repository
 
 import (
 	"balancer/pkg/data"
+	"balancer/pkg/disk"
 	"balancer/pkg/errs"
 	"fmt"
 	"io"
@@ -39,7 +40,30 @@ func (f *File) Write(r io.Reader) (hash string, size int, e error) {
 	return hash, size, nil
 }
 
-func (f *File) Read(hash string) (r io.ReadCloser, e error) {
+// Spill writes the data to a file with a unique name, which is returned
+// to read and remove it like hashed files.
+func (f *File) Spill(r io.Reader) (name string, size int, e error) {
+	if err := data.EnsureDir(f.path); err != nil {
+		return "", 0, fmt.Errorf("create dir: %w", err)
+	}
+
+	name = "spill-" + shortuuid.New()
+	file, err := os.Create(filepath.Join(f.path, name))
+	if err != nil {
+		return "", 0, fmt.Errorf("create file: %w", err)
+	}
+	defer errs.Close(&e, file.Close)
+
+	written, err := io.Copy(file, r)
+	if err != nil {
+		data.SilentRemove(filepath.Join(f.path, name))
+		return "", 0, fmt.Errorf("write file: %w", err)
+	}
+
+	return name, int(written), nil
+}
+
+func (f *File) Read(hash string) (r io.ReadSeekCloser, e error) {
 	now := filepath.Join(f.path, hash)
 	file, err := os.Open(now)
 	if err != nil {
@@ -73,7 +97,44 @@ func (f *File) Import(path string) (hash string, size int, e error) {
 	return f.Write(file)
 }
 
+func (f *File) Export(hash, name string) (e error) {
+	was := filepath.Join(f.path, hash)
+	now := filepath.Join(f.path, name)
+	if err := os.Rename(was, now); err != nil {
+		return fmt.Errorf("rename file: %w", err)
+	}
+
+	return nil
+}
+
 func (f *File) Remove(hash string) {
 	now := filepath.Join(f.path, hash)
 	data.SilentRemove(now)
 }
+
+// Usage returns the disk usage of the dir, making sure it is writable.
+func (f *File) Usage() (u disk.Usage, e error) {
+	if err := data.EnsureDir(f.path); err != nil {
+		return disk.Usage{}, fmt.Errorf("create dir: %w", err)
+	}
+	if err := disk.Writable(f.path); err != nil {
+		return disk.Usage{}, fmt.Errorf("probe dir: %w", err)
+	}
+
+	usage, err := disk.Stat(f.path)
+	if err != nil {
+		return disk.Usage{}, fmt.Errorf("stat dir: %w", err)
+	}
+
+	return usage, nil
+}
+
+// Size returns the files kept in the dir and their bytes.
+func (f *File) Size() (s disk.Size, e error) {
+	size, err := disk.Measure(f.path)
+	if err != nil {
+		return disk.Size{}, fmt.Errorf("measure dir: %w", err)
+	}
+
+	return size, nil
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"balancer/pkg/kv"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+)
+
+const indexFile = "index.json"
+
+// Index maps part names to the hashes of their files. How many names refer
+// to every hash is kept in memory, so releasing a file does not scan them.
+type Index struct {
+	store *kv.Store[string]
+	mu    sync.Mutex
+	refs  map[string]int
+}
+
+func NewIndex(path string) (*Index, error) {
+	store, err := kv.Open[string](filepath.Join(path, indexFile))
+	if err != nil {
+		return nil, fmt.Errorf("open index: %w", err)
+	}
+
+	refs := map[string]int{}
+	store.Range(func(_, hash string) bool {
+		refs[hash]++
+		return true
+	})
+
+	return &Index{store: store, refs: refs}, nil
+}
+
+func (i *Index) Put(name, hash string) (e error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	was, existed := i.store.Get(name)
+	if err := i.store.Put(name, hash); err != nil {
+		return fmt.Errorf("put %s: %w", name, err)
+	}
+	if existed {
+		i.unref(was)
+	}
+	i.refs[hash]++
+
+	return nil
+}
+
+func (i *Index) Get(name string) (hash string, e error) {
+	hash, ok := i.store.Get(name)
+	if !ok {
+		return "", fmt.Errorf("get %s: %w", name, fs.ErrNotExist)
+	}
+
+	return hash, nil
+}
+
+func (i *Index) Delete(name string) (e error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	was, existed := i.store.Get(name)
+	if err := i.store.Delete(name); err != nil {
+		return fmt.Errorf("delete %s: %w", name, err)
+	}
+	if existed {
+		i.unref(was)
+	}
+
+	return nil
+}
+
+func (i *Index) Refs(hash string) (count int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.refs[hash]
+}
+
+func (i *Index) unref(hash string) {
+	i.refs[hash]--
+	if i.refs[hash] <= 0 {
+		delete(i.refs, hash)
+	}
+}
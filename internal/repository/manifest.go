@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"balancer/internal/service"
+	"balancer/pkg/kv"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+const manifestFile = "manifest.json"
+
+type Manifest struct {
+	store *kv.Store[service.Manifest]
+}
+
+func NewManifest(path string) (*Manifest, error) {
+	store, err := kv.Open[service.Manifest](filepath.Join(path, manifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("open manifest: %w", err)
+	}
+
+	return &Manifest{store: store}, nil
+}
+
+func (m *Manifest) Put(manifest service.Manifest) (e error) {
+	if err := m.store.Put(manifest.Name, manifest); err != nil {
+		return fmt.Errorf("put %s: %w", manifest.Name, err)
+	}
+
+	return nil
+}
+
+func (m *Manifest) Get(name string) (manifest service.Manifest, e error) {
+	manifest, ok := m.store.Get(name)
+	if !ok {
+		return service.Manifest{}, fmt.Errorf("get %s: %w", name, fs.ErrNotExist)
+	}
+
+	return manifest, nil
+}
+
+// List returns up to limit manifests with names starting with prefix in name
+// order, continuing after the given name.
+func (m *Manifest) List(prefix, after string, limit int) (manifests []service.Manifest, more bool, e error) {
+	manifests = make([]service.Manifest, 0, limit)
+	for _, name := range m.store.Keys() {
+		if !strings.HasPrefix(name, prefix) || name <= after {
+			continue
+		}
+		if len(manifests) == limit {
+			return manifests, true, nil
+		}
+		if manifest, ok := m.store.Get(name); ok {
+			manifests = append(manifests, manifest)
+		}
+	}
+
+	return manifests, false, nil
+}
+
+func (m *Manifest) Delete(name string) (e error) {
+	if err := m.store.Delete(name); err != nil {
+		return fmt.Errorf("delete %s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"balancer/internal/service"
+	"balancer/pkg/data"
+	"balancer/pkg/errs"
+	"balancer/pkg/kv"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	sessionFile = "sessions.json"
+	sessionDir  = "sessions"
+)
+
+// Session keeps upload sessions in a store and their received bytes in
+// partial files next to it.
+type Session struct {
+	path  string
+	store *kv.Store[service.Session]
+}
+
+func NewSession(path string) (*Session, error) {
+	store, err := kv.Open[service.Session](filepath.Join(path, sessionFile))
+	if err != nil {
+		return nil, fmt.Errorf("open sessions: %w", err)
+	}
+
+	return &Session{path: filepath.Join(path, sessionDir), store: store}, nil
+}
+
+func (s *Session) Put(session service.Session) (e error) {
+	if err := s.store.Put(session.ID, session); err != nil {
+		return fmt.Errorf("put %s: %w", session.ID, err)
+	}
+
+	return nil
+}
+
+func (s *Session) Get(id string) (session service.Session, e error) {
+	session, ok := s.store.Get(id)
+	if !ok {
+		return service.Session{}, fmt.Errorf("get %s: %w", id, fs.ErrNotExist)
+	}
+
+	return session, nil
+}
+
+func (s *Session) List() (sessions []service.Session, e error) {
+	sessions = make([]service.Session, 0)
+	s.store.Range(func(_ string, session service.Session) bool {
+		sessions = append(sessions, session)
+		return true
+	})
+
+	return sessions, nil
+}
+
+// Delete forgets the session and removes its partial file.
+func (s *Session) Delete(id string) (e error) {
+	data.SilentRemove(filepath.Join(s.path, id))
+	if err := s.store.Delete(id); err != nil {
+		return fmt.Errorf("delete %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// Append writes up to limit bytes to the partial file at the offset. Bytes
+// past the offset left by an interrupted write are dropped first. The
+// written count is returned along with a read error.
+func (s *Session) Append(id string, offset int, r io.Reader, limit int) (n int, e error) {
+	if err := data.EnsureDir(s.path); err != nil {
+		return 0, fmt.Errorf("create dir: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(s.path, id), os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("open file: %w", err)
+	}
+	defer errs.Close(&e, file.Close)
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat file: %w", err)
+	}
+	if info.Size() < int64(offset) {
+		return 0, fmt.Errorf("file has %d bytes, offset is %d: %w", info.Size(), offset, service.ErrOffset)
+	}
+	if err := file.Truncate(int64(offset)); err != nil {
+		return 0, fmt.Errorf("truncate file: %w", err)
+	}
+	if _, err := file.Seek(int64(offset), io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek file: %w", err)
+	}
+
+	// Received bytes are kept even when the client went away, so it resumes
+	// from them, they are synced before the offset is committed
+	written, err := io.Copy(file, io.LimitReader(r, int64(limit)))
+	if serr := file.Sync(); serr != nil {
+		return 0, fmt.Errorf("sync file: %w", serr)
+	}
+	if err != nil {
+		return int(written), fmt.Errorf("write file: %w", err)
+	}
+
+	return int(written), nil
+}
+
+func (s *Session) Open(id string) (r io.ReadCloser, e error) {
+	file, err := os.Open(filepath.Join(s.path, id))
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+
+	return file, nil
+}
@@ -1,34 +1,145 @@
 package repository
 
 import (
+	"balancer/internal/service"
+	"balancer/pkg/breaker"
 	"balancer/pkg/data"
 	"balancer/pkg/errs"
 	"balancer/pkg/maglev"
+	"balancer/pkg/metrics"
+	"balancer/pkg/retry"
+	"balancer/pkg/trace"
 	"balancer/pkg/validation"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 )
 
 type Storage struct {
-	timeout time.Duration
-	hasher  *maglev.Hasher
+	timeout  time.Duration
+	replicas int
+	backoff  retry.Backoff
+	eject    bool
+	breaker  *breaker.Breaker
+	hasher   *maglev.Hasher
+	latency  *metrics.Histogram
+	failures *metrics.Counter
+	mu       sync.Mutex
+	ejected  []string
 }
 
-func NewStorage(timeout time.Duration, backends []string) *Storage {
-	s := &Storage{timeout: timeout}
+// NewStorage opens the circuit of a storage after the number of consecutive
+// failures. With eject set such storage is also taken out of the lookup
+// table, so new parts go elsewhere, and put back once its circuit closes.
+// Parts already placed on it are still read from it. Request latencies and
+// errors are registered in the registry.
+func NewStorage(
+	timeout time.Duration,
+	backends []string,
+	replicas int,
+	backoff retry.Backoff,
+	failures int,
+	cooldown time.Duration,
+	eject bool,
+	registry *metrics.Registry,
+) *Storage {
+	s := &Storage{
+		timeout:  timeout,
+		replicas: replicas,
+		backoff:  backoff,
+		eject:    eject,
+		latency: registry.Histogram(
+			"balancer_storage_request_seconds", "Storage request latency by backend and operation.",
+			metrics.DefaultBuckets, "backend", "op",
+		),
+		failures: registry.Counter(
+			"balancer_storage_errors_total", "Failed storage requests by backend and operation.",
+			"backend", "op",
+		),
+	}
+	s.breaker = breaker.New(failures, cooldown, s.change)
 	s.hasher = maglev.NewHasher(maglev.DefaultPrime)
 	s.hasher.AddBackends(backends)
 	return s
 }
 
-func (s *Storage) Save(name string, part int, r io.Reader, limit int) (e error) {
-	flow := fmt.Sprintf("name-%s:part-%d", name, part)
-	backend := s.hasher.GetBackend(flow)
+func (s *Storage) Locate(name string, part int) (backends []string) {
+	return s.hasher.GetBackends(flow(name, part), s.replicas)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+// Spread places all parts of a stripe with their replicas on distinct
+// backends by a single walk of the lookup table from the first part entry.
+// Backends are only reused when there are fewer than parts times replicas,
+// replicas of one part stay distinct then.
+func (s *Storage) Spread(name string, parts int) [][]string {
+	walk := s.hasher.GetBackends(flow(name, 0), parts*s.replicas)
+	placed := make([][]string, parts)
+	if len(walk) == 0 {
+		return placed
+	}
+
+	for i := range placed {
+		for j := range min(s.replicas, len(walk)) {
+			placed[i] = append(placed[i], walk[(i*s.replicas+j)%len(walk)])
+		}
+	}
+	return placed
+}
+
+// Save sends the part with its expected digest, the storage refuses the part
+// when it does not match. An empty digest is not checked. Failed transfers
+// are retried with the part opened again.
+func (s *Storage) Save(
+	ctx context.Context,
+	backend, name string,
+	part int,
+	digest string,
+	open func() (io.ReadCloser, error),
+	limit int,
+) error {
+	return s.backoff.Do(ctx, func(attempt int) error {
+		ctx, span := trace.Start(ctx, "save part")
+		defer span.Finish()
+		span.Set("backend", backend)
+		span.Set("part", part)
+		span.Set("attempt", attempt)
+
+		err := s.call(backend, "save", func() error {
+			return s.save(ctx, backend, name, part, digest, open, limit)
+		})
+		if err != nil {
+			slog.Error("save part", "flow", flow(name, part), "backend", backend, "attempt", attempt, "error", err)
+		}
+		span.Fail(err)
+		return err
+	})
+}
+
+func (s *Storage) save(
+	ctx context.Context,
+	backend, name string,
+	part int,
+	digest string,
+	open func() (io.ReadCloser, error),
+	limit int,
+) (e error) {
+	flow := flow(name, part)
+
+	r, err := open()
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("open part: %w", err))
+	}
+	defer errs.Close(&e, r.Close)
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
 	url := fmt.Sprintf("http://%s/parts/%s", backend, flow)
@@ -37,6 +148,147 @@ func (s *Storage) Save(name string, part int, r io.Reader, limit int) (e error)
 		limit, data.SlogProgress(fmt.Sprintf("%s -> %s", flow, backend)),
 	)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, reader)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("build request: %w", err))
+	}
+	if digest != "" {
+		req.Header.Set("Digest", digest)
+	}
+	trace.Inject(ctx, req.Header)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer errs.Close(&e, res.Body.Close)
+
+	if res.StatusCode == http.StatusBadRequest {
+		// The part got corrupt on the way, sending it again may help
+		return fmt.Errorf("error code %d: %w", res.StatusCode, service.ErrInvalidPart)
+	}
+	if !validation.SuccessStatus(res.StatusCode) {
+		return status(res.StatusCode)
+	}
+
+	return nil
+}
+
+// Load opens the part at the offset, failed requests are retried before
+// anything is read. Cancelling the context stops the retries and the read.
+func (s *Storage) Load(ctx context.Context, backend, name string, part, offset int) (r io.ReadCloser, e error) {
+	err := s.backoff.Do(ctx, func(attempt int) error {
+		err := s.call(backend, "load", func() (err error) {
+			r, err = s.load(ctx, backend, name, part, offset)
+			return err
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("load part", "flow", flow(name, part), "backend", backend, "attempt", attempt, "error", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (s *Storage) load(ctx context.Context, backend, name string, part, offset int) (r io.ReadCloser, e error) {
+	flow := flow(name, part)
+
+	cancelled := ctx
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+
+	url := fmt.Sprintf("http://%s/parts/%s", backend, flow)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, retry.Permanent(fmt.Errorf("build request: %w", err))
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		if cancelled.Err() != nil {
+			// The caller gave up, the storage is not to blame
+			return nil, retry.Permanent(fmt.Errorf("do request: %w", err))
+		}
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+
+	if res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// Nothing is left past the offset
+		_ = res.Body.Close()
+		cancel()
+		return io.NopCloser(&bytes.Reader{}), nil
+	}
+	if !validation.SuccessStatus(res.StatusCode) {
+		_ = res.Body.Close()
+		cancel()
+		if res.StatusCode == http.StatusNotFound {
+			return nil, retry.Permanent(fmt.Errorf("%s on %s: %w", flow, backend, fs.ErrNotExist))
+		}
+		return nil, status(res.StatusCode)
+	}
+
+	reader := data.NewProgressReader(
+		res.Body, int(max(res.ContentLength, 0)),
+		data.SlogProgress(fmt.Sprintf("%s <- %s", flow, backend)),
+	)
+	return data.NewCancelReadCloser(reader, res.Body, cancel), nil
+}
+
+func (s *Storage) Delete(backend, name string, part int) (e error) {
+	return s.call(backend, "delete", func() error {
+		return s.delete(backend, name, part)
+	})
+}
+
+func (s *Storage) delete(backend, name string, part int) (e error) {
+	flow := flow(name, part)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s/parts/%s", backend, flow)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer errs.Close(&e, res.Body.Close)
+
+	if res.StatusCode == http.StatusNotFound {
+		return retry.Permanent(fmt.Errorf("%s on %s: %w", flow, backend, fs.ErrNotExist))
+	}
+	if !validation.SuccessStatus(res.StatusCode) {
+		return status(res.StatusCode)
+	}
+
+	return nil
+}
+
+// Check asks the storage for its health. The outcome drives its circuit
+// regardless of its state, so a recovered storage gets its circuit closed.
+func (s *Storage) Check(backend string) (e error) {
+	start := time.Now()
+	defer func() {
+		s.breaker.Report(backend, time.Since(start), e != nil)
+		s.observe(backend, "check", time.Since(start), e)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s/health", backend)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return fmt.Errorf("build request: %w", err)
 	}
@@ -54,6 +306,105 @@ func (s *Storage) Save(name string, part int, r io.Reader, limit int) (e error)
 	return nil
 }
 
-func (s *Storage) Backends() int {
-	return s.hasher.BackendsNum()
+func (s *Storage) Health() map[string]breaker.Stats {
+	return s.breaker.Stats()
+}
+
+// call refuses calls to storages with open circuits and reports the outcome
+// of the others. Permanent errors are caused by requests, not storages.
+func (s *Storage) call(backend, op string, fn func() error) error {
+	if !s.breaker.Allow(backend) {
+		s.failures.Inc(backend, op)
+		return retry.Permanent(fmt.Errorf("%s: %w", backend, breaker.ErrOpen))
+	}
+
+	start := time.Now()
+	err := fn()
+	latency := time.Since(start)
+	s.breaker.Report(backend, latency, err != nil && !retry.IsPermanent(err))
+	s.observe(backend, op, latency, err)
+	return err
+}
+
+func (s *Storage) observe(backend, op string, latency time.Duration, err error) {
+	s.latency.Observe(latency.Seconds(), backend, op)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.failures.Inc(backend, op)
+	}
+}
+
+func (s *Storage) change(backend string, state breaker.State) {
+	slog.Info("storage circuit", "backend", backend, "state", state)
+	if !s.eject {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Callbacks run outside the breaker lock and may arrive out of order,
+	// the circuit is read again so the latest state wins
+	state = s.breaker.State(backend)
+	active := s.hasher.Backends()
+	switch {
+	case state == breaker.Open && slices.Contains(active, backend) && len(active) > 1:
+		s.hasher.RemoveBackends([]string{backend})
+		s.ejected = append(s.ejected, backend)
+		slog.Error("storage ejected", "backend", backend)
+	case state == breaker.Closed && slices.Contains(s.ejected, backend):
+		s.ejected = slices.DeleteFunc(s.ejected, func(b string) bool { return b == backend })
+		s.hasher.AddBackends([]string{backend})
+		slog.Info("storage restored", "backend", backend)
+	}
+}
+
+func (s *Storage) Add(backends []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ejected = slices.DeleteFunc(s.ejected, func(b string) bool {
+		return slices.Contains(backends, b)
+	})
+	s.hasher.AddBackends(backends)
+}
+
+func (s *Storage) Remove(backends []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ejected = slices.DeleteFunc(s.ejected, func(b string) bool {
+		return slices.Contains(backends, b)
+	})
+	s.hasher.RemoveBackends(backends)
+	for _, backend := range backends {
+		s.breaker.Forget(backend)
+	}
+}
+
+func (s *Storage) Table() []string {
+	return s.hasher.LookupTable()
+}
+
+// Backends returns the storages set, including the ejected storages.
+func (s *Storage) Backends() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append(s.hasher.Backends(), s.ejected...)
+}
+
+// status returns the error for the response code, only server errors and
+// throttling are worth retrying.
+func status(code int) error {
+	err := fmt.Errorf("error code %d", code)
+	if code >= http.StatusInternalServerError ||
+		code == http.StatusRequestTimeout ||
+		code == http.StatusTooManyRequests {
+		return err
+	}
+	return retry.Permanent(err)
+}
+
+func flow(name string, part int) string {
+	return fmt.Sprintf("name-%s:part-%d", name, part)
 }
@@ -0,0 +1,39 @@
+package service
+
+import "fmt"
+
+type Catalog struct {
+	manifests ManifestRepository
+}
+
+func NewCatalog(manifests ManifestRepository) *Catalog {
+	return &Catalog{manifests: manifests}
+}
+
+type Page struct {
+	Files []Manifest `json:"files"`
+	Next  string     `json:"next,omitempty"`
+}
+
+func (c *Catalog) List(prefix, after string, limit int) (p Page, e error) {
+	manifests, more, err := c.manifests.List(prefix, after, limit)
+	if err != nil {
+		return Page{}, fmt.Errorf("list manifests: %w", err)
+	}
+
+	page := Page{Files: manifests}
+	if more && len(manifests) > 0 {
+		page.Next = manifests[len(manifests)-1].Name
+	}
+
+	return page, nil
+}
+
+func (c *Catalog) Stat(name string) (m Manifest, e error) {
+	manifest, err := c.manifests.Get(name)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("get manifest: %w", err)
+	}
+
+	return manifest, nil
+}
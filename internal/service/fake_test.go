@@ -0,0 +1,363 @@
+package service
+
+import (
+	"balancer/pkg/breaker"
+	"balancer/pkg/disk"
+	"balancer/pkg/errs"
+	"balancer/pkg/header"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const backend = "127.0.0.1:9000"
+
+// fakeStorages serves stored parts from memory. Every part is located on
+// the same storages. Loads of a part can be delayed, held until a gate is
+// closed or failed, saves of a part can be refused. Loads, loads cancelled
+// while held and deletes are recorded.
+type fakeStorages struct {
+	mu      sync.Mutex
+	located []string
+	parts   map[slot][]byte
+	delays  map[int]time.Duration
+	gates   map[int]chan struct{}
+	fails   map[int]error
+	refuses map[int]error
+	loads   []int
+	cancels []int
+	deletes []int
+}
+
+// slot is a part on a storage.
+type slot struct {
+	backend string
+	part    int
+}
+
+func newFakeStorages() *fakeStorages {
+	return &fakeStorages{
+		located: []string{backend},
+		parts:   make(map[slot][]byte),
+		delays:  make(map[int]time.Duration),
+		gates:   make(map[int]chan struct{}),
+		fails:   make(map[int]error),
+		refuses: make(map[int]error),
+	}
+}
+
+// store cuts the payload into parts of the chunk size and stores them with
+// their headers where they are located, returning the manifest of the file.
+func (s *fakeStorages) store(t *testing.T, payload []byte, size int) Manifest {
+	t.Helper()
+
+	manifest := Manifest{
+		Name:    "file",
+		Digest:  hash(payload),
+		Size:    len(payload),
+		Chunk:   size,
+		Parts:   chunk(len(payload), size),
+		Version: header.Version,
+	}
+	for i := range manifest.Parts {
+		part := &manifest.Parts[i]
+		data := payload[part.Offset : part.Offset+part.Size]
+		part.Digest = hash(data)
+		part.Backends = slices.Clone(s.located)
+
+		prefix, err := header.Header{
+			Version: manifest.Version,
+			Count:   len(manifest.Parts),
+			Chunk:   manifest.Chunk,
+			Size:    manifest.Size,
+			Index:   part.Index,
+			Length:  part.Size,
+			Digest:  manifest.Digest,
+			Sum:     part.Digest,
+		}.MarshalBinary()
+		require.NoError(t, err)
+		for _, backend := range part.Backends {
+			s.parts[slot{backend, part.Index}] = append(prefix, data...)
+		}
+	}
+
+	return manifest
+}
+
+func (s *fakeStorages) loaded() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]int(nil), s.loads...)
+}
+
+func (s *fakeStorages) cancelled() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(slices.Values(s.cancels))
+}
+
+func (s *fakeStorages) deleted() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(slices.Values(s.deletes))
+}
+
+func (s *fakeStorages) stored(backend string, part int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.parts[slot{backend, part}]
+	return ok
+}
+
+// locate places the parts located from now on.
+func (s *fakeStorages) locate(backends ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.located = backends
+}
+
+func (s *fakeStorages) Load(ctx context.Context, backend, _ string, part, offset int) (io.ReadCloser, error) {
+	s.mu.Lock()
+	s.loads = append(s.loads, part)
+	stored, ok := s.parts[slot{backend, part}]
+	delay, gate, fail := s.delays[part], s.gates[part], s.fails[part]
+	s.mu.Unlock()
+
+	time.Sleep(delay)
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.cancels = append(s.cancels, part)
+			s.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	if fail != nil {
+		return nil, fail
+	}
+	if !ok || offset > len(stored) {
+		return nil, fmt.Errorf("part %d: %w", part, fs.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(stored[offset:])), nil
+}
+
+func (s *fakeStorages) Locate(string, int) []string { return s.Backends() }
+
+func (s *fakeStorages) Spread(_ string, parts int) [][]string {
+	placed := make([][]string, parts)
+	for i := range placed {
+		placed[i] = s.Backends()
+	}
+	return placed
+}
+
+func (s *fakeStorages) Save(_ context.Context, backend, _ string, part int, _ string, open func() (io.ReadCloser, error), _ int) (e error) {
+	s.mu.Lock()
+	refuse := s.refuses[part]
+	s.mu.Unlock()
+	if refuse != nil {
+		return refuse
+	}
+
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	defer errs.Close(&e, reader.Close)
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.parts[slot{backend, part}] = data
+	return nil
+}
+
+func (s *fakeStorages) Delete(backend, _ string, part int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deletes = append(s.deletes, part)
+	if _, ok := s.parts[slot{backend, part}]; !ok {
+		return fmt.Errorf("part %d: %w", part, fs.ErrNotExist)
+	}
+	delete(s.parts, slot{backend, part})
+	return nil
+}
+
+func (s *fakeStorages) Add([]string) {}
+
+func (s *fakeStorages) Remove([]string) {}
+
+func (s *fakeStorages) Table() []string { return s.Backends() }
+
+func (s *fakeStorages) Backends() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.located)
+}
+
+func (s *fakeStorages) Check(string) error { return nil }
+
+func (s *fakeStorages) Health() map[string]breaker.Stats { return nil }
+
+// fakeFiles keeps spilled files in memory and counts the ones not removed.
+type fakeFiles struct {
+	mu      sync.Mutex
+	files   map[string][]byte
+	spilled int
+}
+
+func newFakeFiles() *fakeFiles {
+	return &fakeFiles{files: make(map[string][]byte)}
+}
+
+func (f *fakeFiles) left() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.files)
+}
+
+func (f *fakeFiles) Spill(r io.Reader) (string, int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.spilled++
+	name := fmt.Sprintf("spill-%d", f.spilled)
+	f.files[name] = data
+	return name, len(data), nil
+}
+
+func (f *fakeFiles) Read(name string) (io.ReadSeekCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, ok := f.files[name]
+	if !ok {
+		return nil, fmt.Errorf("read %s: %w", name, fs.ErrNotExist)
+	}
+	return nopSeekCloser{bytes.NewReader(data)}, nil
+}
+
+func (f *fakeFiles) Remove(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.files, name)
+}
+
+func (f *fakeFiles) Write(io.Reader) (string, int, error) { return "", 0, errors.ErrUnsupported }
+
+func (f *fakeFiles) Seek(name string, offset int) (io.ReadCloser, error) {
+	reader, err := f.Read(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := reader.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (f *fakeFiles) Import(string) (string, int, error) { return "", 0, errors.ErrUnsupported }
+
+func (f *fakeFiles) Export(string, string) error { return errors.ErrUnsupported }
+
+func (f *fakeFiles) Usage() (disk.Usage, error) { return disk.Usage{}, nil }
+
+func (f *fakeFiles) Size() (disk.Size, error) { return disk.Size{}, nil }
+
+// fakeManifests keeps manifests in memory.
+type fakeManifests struct {
+	mu        sync.Mutex
+	manifests map[string]Manifest
+}
+
+func newFakeManifests() *fakeManifests {
+	return &fakeManifests{manifests: make(map[string]Manifest)}
+}
+
+func (m *fakeManifests) Put(manifest Manifest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.manifests[manifest.Name] = manifest
+	return nil
+}
+
+func (m *fakeManifests) Get(name string) (Manifest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	manifest, ok := m.manifests[name]
+	if !ok {
+		return Manifest{}, fmt.Errorf("manifest %s: %w", name, fs.ErrNotExist)
+	}
+	return manifest, nil
+}
+
+func (m *fakeManifests) List(prefix, after string, limit int) ([]Manifest, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := slices.Sorted(maps.Keys(m.manifests))
+	manifests := make([]Manifest, 0, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && name > after {
+			manifests = append(manifests, m.manifests[name])
+		}
+	}
+	if len(manifests) > limit {
+		return manifests[:limit], true, nil
+	}
+	return manifests, false, nil
+}
+
+func (m *fakeManifests) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.manifests, name)
+	return nil
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
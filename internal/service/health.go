@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Health checks every storage periodically, so the circuit of a dead
+// storage opens before uploads run into it and closes once it recovers.
+type Health struct {
+	storages StorageRepository
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func WatchHealth(storages StorageRepository, interval time.Duration) *Health {
+	h := &Health{
+		storages: storages,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go h.run()
+	return h
+}
+
+func (h *Health) run() {
+	defer close(h.done)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			h.check()
+		}
+	}
+}
+
+func (h *Health) check() {
+	wg := sync.WaitGroup{}
+	for _, backend := range h.storages.Backends() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.storages.Check(backend); err != nil {
+				slog.Error("health check", "backend", backend, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (h *Health) Close(ctx context.Context) error {
+	close(h.stop)
+
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
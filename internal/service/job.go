@@ -0,0 +1,207 @@
+package service
+
+import (
+	"balancer/pkg/data"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/lithammer/shortuuid/v4"
+)
+
+const jobExpiration = time.Hour
+
+type JobState string
+
+const (
+	JobPending      JobState = "pending"
+	JobHashing      JobState = "hashing"
+	JobDistributing JobState = "distributing"
+	JobDone         JobState = "done"
+	JobFailed       JobState = "failed"
+)
+
+type JobStatus struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash,omitempty"`
+	State   JobState  `json:"state"`
+	Parts   []JobPart `json:"parts,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+type JobPart struct {
+	Index    int      `json:"index"`
+	Backends []string `json:"backends"`
+	State    JobState `json:"state"`
+	Progress string   `json:"progress,omitempty"`
+	Percent  string   `json:"percent,omitempty"`
+	Estimate string   `json:"estimate,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type Job struct {
+	mu     sync.RWMutex
+	status JobStatus
+}
+
+func (j *Job) ID() string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.status.ID
+}
+
+func (j *Job) Status() JobStatus {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	status := j.status
+	status.Parts = append([]JobPart(nil), j.status.Parts...)
+	return status
+}
+
+func (j *Job) Hashed(hash string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status.Hash = hash
+	j.status.State = JobDistributing
+	j.status.Updated = time.Now().UTC()
+}
+
+// Stream marks the job distributing while the body is still received, the
+// hash is only known once it is complete.
+func (j *Job) Stream() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status.State = JobDistributing
+	j.status.Updated = time.Now().UTC()
+}
+
+func (j *Job) Distribute(parts []Part) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status.Parts = make([]JobPart, len(parts))
+	for i, part := range parts {
+		j.status.Parts[i] = JobPart{
+			Index:    part.Index,
+			Backends: part.Backends,
+			State:    JobPending,
+		}
+	}
+	j.status.Updated = time.Now().UTC()
+}
+
+func (j *Job) Progress(index int) data.Progress {
+	return func(progress string, percent string, estimate string) {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		part := &j.status.Parts[index]
+		part.State = JobDistributing
+		part.Progress = progress
+		part.Percent = percent
+		part.Estimate = estimate
+		j.status.Updated = time.Now().UTC()
+	}
+}
+
+func (j *Job) Complete(index int, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	part := &j.status.Parts[index]
+	part.State, part.Error = outcome(err)
+	if err == nil {
+		part.Progress = ""
+		part.Percent = "100%"
+		part.Estimate = ""
+	}
+	j.status.Updated = time.Now().UTC()
+}
+
+func (j *Job) Finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status.State, j.status.Error = outcome(err)
+	j.status.Updated = time.Now().UTC()
+}
+
+func (j *Job) expired(now time.Time) bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	finished := j.status.State == JobDone || j.status.State == JobFailed
+	return finished && now.Sub(j.status.Updated) > jobExpiration
+}
+
+func outcome(err error) (JobState, string) {
+	if err != nil {
+		return JobFailed, err.Error()
+	}
+	return JobDone, ""
+}
+
+type Jobs struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+func NewJobs() *Jobs {
+	return &Jobs{jobs: map[string]*Job{}}
+}
+
+func (s *Jobs) Create(name string) *Job {
+	now := time.Now().UTC()
+	job := &Job{
+		status: JobStatus{
+			ID:      shortuuid.New(),
+			Name:    name,
+			State:   JobHashing,
+			Created: now,
+			Updated: now,
+		},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, j := range s.jobs {
+		if j.expired(now) {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.status.ID] = job
+
+	return job
+}
+
+func (s *Jobs) Get(id string) (status JobStatus, e error) {
+	s.mu.RLock()
+	job, ok := s.jobs[id]
+	s.mu.RUnlock()
+
+	if !ok {
+		return JobStatus{}, fmt.Errorf("job %s: %w", id, fs.ErrNotExist)
+	}
+
+	return job.Status(), nil
+}
+
+// States counts the jobs that are not expired yet by their state.
+func (s *Jobs) States() map[JobState]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make(map[JobState]int)
+	for _, job := range s.jobs {
+		states[job.Status().State]++
+	}
+	return states
+}
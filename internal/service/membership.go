@@ -0,0 +1,161 @@
+package service
+
+import (
+	"balancer/pkg/breaker"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+var ErrTooFew = errors.New("too few storages would remain")
+
+type ClusterStatus struct {
+	Ready       bool     `json:"ready"`
+	Reachable   int      `json:"reachable"`
+	Storages    int      `json:"storages"`
+	Unreachable []string `json:"unreachable"`
+}
+
+// Membership changes the storages set at runtime. Removed storages are
+// drained: they get no new parts, but manifests still point reads to them.
+type Membership struct {
+	storages StorageRepository
+	quorum   int
+	least    int
+	previous []string
+	mu       sync.Mutex
+}
+
+// NewMembership reports the cluster as ready while at least quorum storages
+// are reachable, so that uploads can be acknowledged. Changes leaving fewer
+// than least storages, the replicas or the data and parity parts to place,
+// are refused.
+func NewMembership(storages StorageRepository, quorum, least int) *Membership {
+	return &Membership{
+		storages: storages,
+		quorum:   quorum,
+		least:    max(least, 1),
+	}
+}
+
+func (m *Membership) List() []string {
+	return m.storages.Backends()
+}
+
+// Status answers from the last known state of every storage instead of
+// calling them, so probes stay fast while storages hang. A storage is
+// unreachable while its circuit is open or its last call failed, storages
+// not called yet are taken as reachable.
+func (m *Membership) Status() ClusterStatus {
+	backends := m.storages.Backends()
+	health := m.storages.Health()
+
+	status := ClusterStatus{
+		Storages:    len(backends),
+		Unreachable: make([]string, 0),
+	}
+	for _, backend := range backends {
+		stats, ok := health[backend]
+		if ok && (stats.State == breaker.Open || stats.Failed.After(stats.Succeeded)) {
+			status.Unreachable = append(status.Unreachable, backend)
+			continue
+		}
+		status.Reachable++
+	}
+	status.Ready = status.Reachable >= max(m.quorum, 1)
+
+	return status
+}
+
+// Health returns the circuit state, the failures and the latency of every
+// storage that was called.
+func (m *Membership) Health() map[string]breaker.Stats {
+	return m.storages.Health()
+}
+
+func (m *Membership) Add(backends []string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.previous = m.storages.Table()
+	m.storages.Add(backends)
+	slog.Info("storages added", "backends", backends)
+	return m.storages.Backends()
+}
+
+func (m *Membership) Remove(backends []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.storages.Backends()
+	left := slices.DeleteFunc(slices.Clone(current), func(b string) bool {
+		return slices.Contains(backends, b)
+	})
+	if err := m.enough(left); err != nil {
+		return current, err
+	}
+
+	m.previous = m.storages.Table()
+	m.storages.Remove(backends)
+	slog.Info("storages removed", "backends", backends)
+	return m.storages.Backends(), nil
+}
+
+// Tables returns the lookup tables before and after the last change.
+func (m *Membership) Tables() (previous, current []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current = m.storages.Table()
+	if m.previous == nil {
+		return current, current
+	}
+	return m.previous, current
+}
+
+// Sync makes the storages set equal to the desired one.
+func (m *Membership) Sync(desired []string) ([]string, error) {
+	if err := m.enough(desired); err != nil {
+		return m.List(), err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.storages.Backends()
+	added := make([]string, 0, len(desired))
+	for _, b := range desired {
+		if !slices.Contains(current, b) {
+			added = append(added, b)
+		}
+	}
+	removed := make([]string, 0, len(current))
+	for _, b := range current {
+		if !slices.Contains(desired, b) {
+			removed = append(removed, b)
+		}
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		m.previous = m.storages.Table()
+	}
+	if len(added) > 0 {
+		m.storages.Add(added)
+		slog.Info("storages added", "backends", added)
+	}
+	if len(removed) > 0 {
+		m.storages.Remove(removed)
+		slog.Info("storages removed", "backends", removed)
+	}
+
+	return m.storages.Backends(), nil
+}
+
+func (m *Membership) enough(backends []string) error {
+	if len(backends) < m.least {
+		return fmt.Errorf("%d storages left, %d needed: %w", len(backends), m.least, ErrTooFew)
+	}
+	return nil
+}
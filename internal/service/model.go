@@ -1,20 +1,110 @@
 package service
 
-import "io"
+import (
+	"balancer/pkg/breaker"
+	"balancer/pkg/disk"
+	"balancer/pkg/header"
+	"context"
+	"io"
+	"time"
+)
+
+type Manifest struct {
+	Name     string    `json:"name"`
+	Digest   string    `json:"digest"`
+	Size     int       `json:"size"`
+	Parts    []Part    `json:"parts"`
+	Backends []string  `json:"backends"`
+	Data     int       `json:"data,omitempty"`
+	Parity   int       `json:"parity,omitempty"`
+	Shard    int       `json:"shard,omitempty"`
+	Chunk    int       `json:"chunk,omitempty"`
+	Version  int       `json:"version,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+// Chunks returns the parts holding file data in order, parity parts of
+// erasure coded files follow them.
+func (m Manifest) Chunks() []Part {
+	if m.Parity == 0 {
+		return m.Parts
+	}
+	return m.Parts[:m.Data]
+}
+
+// Prefix returns the length of the header stored before the part payload,
+// before the parts version only the first part has one.
+func (m Manifest) Prefix(index int) int {
+	if index != 0 && m.Version < header.Parts {
+		return 0
+	}
+	return header.Header{Version: m.Version}.Len()
+}
+
+// Stored returns the part size on a storage.
+func (m Manifest) Stored(part Part) int {
+	return m.Prefix(part.Index) + part.Size
+}
+
+type Part struct {
+	Index    int      `json:"index"`
+	Offset   int      `json:"offset"`
+	Size     int      `json:"size"`
+	Digest   string   `json:"digest"`
+	Backends []string `json:"backends"`
+}
 
 type FileRepository interface {
 	Write(r io.Reader) (hash string, size int, e error)
-	Read(hash string) (r io.ReadCloser, e error)
+	Spill(r io.Reader) (name string, size int, e error)
+	Read(hash string) (r io.ReadSeekCloser, e error)
 	Seek(hash string, offset int) (r io.ReadCloser, e error)
 	Import(path string) (hash string, size int, e error)
+	Export(hash, name string) (e error)
 	Remove(hash string)
+	Usage() (u disk.Usage, e error)
+	Size() (s disk.Size, e error)
 }
 
 type StorageRepository interface {
-	Save(name string, part int, r io.Reader, limit int) (e error)
-	Backends() int
+	Locate(name string, part int) (backends []string)
+	Spread(name string, parts int) (backends [][]string)
+	Save(ctx context.Context, backend, name string, part int, digest string, open func() (io.ReadCloser, error), limit int) (e error)
+	Load(ctx context.Context, backend, name string, part, offset int) (r io.ReadCloser, e error)
+	Delete(backend, name string, part int) (e error)
+	Add(backends []string)
+	Remove(backends []string)
+	Table() []string
+	Backends() []string
+	Check(backend string) (e error)
+	Health() map[string]breaker.Stats
 }
 
 type BalancerRepository interface {
 	Upload(name, hash string, r io.Reader, limit int) (e error)
+	Download(name string) (r io.ReadCloser, digest func() string, e error)
+}
+
+type IndexRepository interface {
+	Put(name, hash string) (e error)
+	Get(name string) (hash string, e error)
+	Delete(name string) (e error)
+	Refs(hash string) (count int)
+}
+
+type SessionRepository interface {
+	Put(session Session) (e error)
+	Get(id string) (session Session, e error)
+	List() (sessions []Session, e error)
+	Delete(id string) (e error)
+	Append(id string, offset int, r io.Reader, limit int) (n int, e error)
+	Open(id string) (r io.ReadCloser, e error)
+}
+
+type ManifestRepository interface {
+	Put(manifest Manifest) (e error)
+	Get(name string) (manifest Manifest, e error)
+	List(prefix, after string, limit int) (manifests []Manifest, more bool, e error)
+	Delete(name string) (e error)
 }
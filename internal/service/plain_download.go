@@ -0,0 +1,49 @@
+package service
+
+import (
+	"balancer/pkg/errs"
+	"fmt"
+	"strings"
+)
+
+type PlainDownload struct {
+	file     FileRepository
+	balancer BalancerRepository
+}
+
+func NewPlainDownload(
+	file FileRepository,
+	balancer BalancerRepository,
+) *PlainDownload {
+	d := &PlainDownload{
+		file:     file,
+		balancer: balancer,
+	}
+	return d
+}
+
+func (d *PlainDownload) Download(name string) (e error) {
+	reader, digest, err := d.balancer.Download(name)
+	if err != nil {
+		return fmt.Errorf("download file: %w", err)
+	}
+	defer errs.Close(&e, reader.Close)
+
+	hash, _, err := d.file.Write(reader)
+	if err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+
+	expected := digest()
+	if !strings.EqualFold(hash, expected) {
+		d.file.Remove(hash)
+		return fmt.Errorf("corrupted data: hash %s, digest %q", hash, expected)
+	}
+
+	if err := d.file.Export(hash, name); err != nil {
+		d.file.Remove(hash)
+		return fmt.Errorf("export file: %w", err)
+	}
+
+	return nil
+}
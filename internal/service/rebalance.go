@@ -0,0 +1,333 @@
+package service
+
+import (
+	"balancer/pkg/conc"
+	"balancer/pkg/data"
+	"balancer/pkg/header"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+const rebalancePage = 1000
+
+var ErrRebalancing = errors.New("rebalance is already running")
+
+type Move struct {
+	Name  string   `json:"name"`
+	Part  int      `json:"part"`
+	Size  int      `json:"size"`
+	From  []string `json:"from"`
+	To    []string `json:"to"`
+	State JobState `json:"state"`
+	Error string   `json:"error,omitempty"`
+}
+
+type RebalanceReport struct {
+	DryRun  bool      `json:"dry_run"`
+	State   JobState  `json:"state"`
+	Entries int       `json:"entries"`
+	Moved   int       `json:"moved_entries"`
+	Moves   []Move    `json:"moves"`
+	Bytes   int       `json:"bytes"`
+	Copied  int       `json:"copied"`
+	Error   string    `json:"error,omitempty"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+}
+
+// Rebalancer copies parts to the storages the hasher maps them to now, so
+// they stay reachable after the storages set changes. Manifests are updated
+// part by part, which makes an interrupted run resumable by starting it again.
+type Rebalancer struct {
+	storages   StorageRepository
+	manifests  ManifestRepository
+	membership *Membership
+	keylock    *conc.KeyRWLock
+	rate       int
+	mu         sync.Mutex
+	report     *RebalanceReport
+	running    bool
+}
+
+func NewRebalancer(
+	storages StorageRepository,
+	manifests ManifestRepository,
+	membership *Membership,
+	keylock *conc.KeyRWLock,
+	rate int,
+) *Rebalancer {
+	return &Rebalancer{
+		storages:   storages,
+		manifests:  manifests,
+		membership: membership,
+		keylock:    keylock,
+		rate:       rate,
+	}
+}
+
+// Plan reports the moves without copying anything.
+func (b *Rebalancer) Plan() (r RebalanceReport, e error) {
+	report, err := b.plan()
+	if err != nil {
+		return RebalanceReport{}, err
+	}
+	report.DryRun = true
+
+	return report, nil
+}
+
+// Start plans the moves and applies them in the background. The run is
+// claimed before planning, which lists every manifest, so the status stays
+// readable meanwhile and a second start is refused.
+func (b *Rebalancer) Start() (r RebalanceReport, e error) {
+	b.mu.Lock()
+	if b.running {
+		b.mu.Unlock()
+		return RebalanceReport{}, ErrRebalancing
+	}
+	b.running = true
+	b.mu.Unlock()
+
+	report, err := b.plan()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil {
+		b.running = false
+		return RebalanceReport{}, err
+	}
+	report.State = JobDistributing
+	b.report = &report
+
+	go b.run()
+	return b.snapshot(), nil
+}
+
+func (b *Rebalancer) Status() (r RebalanceReport, e error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.report == nil {
+		return RebalanceReport{}, fmt.Errorf("rebalance: %w", fs.ErrNotExist)
+	}
+
+	return b.snapshot(), nil
+}
+
+func (b *Rebalancer) snapshot() RebalanceReport {
+	report := *b.report
+	report.Moves = slices.Clone(b.report.Moves)
+	return report
+}
+
+func (b *Rebalancer) plan() (r RebalanceReport, e error) {
+	now := time.Now().UTC()
+	report := RebalanceReport{
+		State:   JobPending,
+		Moves:   make([]Move, 0),
+		Started: now,
+		Updated: now,
+	}
+
+	previous, current := b.membership.Tables()
+	report.Entries = len(current)
+	for i := range current {
+		if i < len(previous) && previous[i] != current[i] {
+			report.Moved++
+		}
+	}
+
+	after := ""
+	for {
+		manifests, more, err := b.manifests.List("", after, rebalancePage)
+		if err != nil {
+			return RebalanceReport{}, fmt.Errorf("list manifests: %w", err)
+		}
+		for _, manifest := range manifests {
+			placed := place(b.storages, manifest)
+			for i, part := range manifest.Parts {
+				move, ok := b.move(manifest, part, placed[i])
+				if !ok {
+					continue
+				}
+				report.Moves = append(report.Moves, move)
+				report.Bytes += move.Size * len(missing(move))
+			}
+			after = manifest.Name
+		}
+		if !more {
+			break
+		}
+	}
+
+	return report, nil
+}
+
+func (b *Rebalancer) move(manifest Manifest, part Part, to []string) (Move, bool) {
+	if slices.Equal(slices.Sorted(slices.Values(to)), slices.Sorted(slices.Values(part.Backends))) {
+		return Move{}, false
+	}
+
+	return Move{
+		Name:  manifest.Name,
+		Part:  part.Index,
+		Size:  manifest.Stored(part),
+		From:  part.Backends,
+		To:    to,
+		State: JobPending,
+	}, true
+}
+
+func (b *Rebalancer) run() {
+	var failed int
+	for i := 0; ; i++ {
+		b.mu.Lock()
+		if i == len(b.report.Moves) {
+			b.mu.Unlock()
+			break
+		}
+		move := b.report.Moves[i]
+		b.mu.Unlock()
+
+		copied, err := b.apply(move)
+
+		b.mu.Lock()
+		b.report.Moves[i].State, b.report.Moves[i].Error = outcome(err)
+		b.report.Copied += copied
+		b.report.Updated = time.Now().UTC()
+		b.mu.Unlock()
+
+		if err != nil {
+			failed++
+			slog.Error("rebalance", "name", move.Name, "part", move.Part, "error", err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var err error
+	if failed > 0 {
+		err = fmt.Errorf("%d of %d moves failed", failed, len(b.report.Moves))
+	}
+	b.report.State, b.report.Error = outcome(err)
+	b.report.Updated = time.Now().UTC()
+	b.running = false
+	slog.Info("rebalanced", "moves", len(b.report.Moves), "failed", failed)
+}
+
+// apply copies the part to new replicas, then points the manifest to them
+// and only then removes the stale ones.
+func (b *Rebalancer) apply(planned Move) (copied int, e error) {
+	b.keylock.Lock(planned.Name)
+	defer b.keylock.Unlock(planned.Name)
+
+	manifest, err := b.manifests.Get(planned.Name)
+	if err != nil {
+		return 0, fmt.Errorf("get manifest: %w", err)
+	}
+	if planned.Part >= len(manifest.Parts) {
+		return 0, nil
+	}
+	part := &manifest.Parts[planned.Part]
+	move, ok := b.move(manifest, *part, place(b.storages, manifest)[planned.Part])
+	if !ok {
+		return 0, nil
+	}
+
+	for _, target := range missing(move) {
+		if err := b.copy(manifest, *part, move, target); err != nil {
+			return copied, fmt.Errorf("copy to %s: %w", target, err)
+		}
+		copied += move.Size
+	}
+
+	part.Backends = move.To
+	if err := b.manifests.Put(manifest); err != nil {
+		return copied, fmt.Errorf("put manifest: %w", err)
+	}
+
+	for _, backend := range move.From {
+		if slices.Contains(move.To, backend) {
+			continue
+		}
+		if err := b.storages.Delete(backend, move.Name, move.Part); err != nil {
+			slog.Error("rebalance stale part", "name", move.Name, "part", move.Part, "backend", backend, "error", err)
+		}
+	}
+
+	return copied, nil
+}
+
+func (b *Rebalancer) copy(manifest Manifest, part Part, move Move, target string) error {
+	failures := make([]error, 0, len(move.From))
+	for _, source := range move.From {
+		if err := b.transfer(manifest, part, move, source, target); err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		return nil
+	}
+
+	return errors.Join(failures...)
+}
+
+// transfer streams the part from the source to the target. Parts with
+// headers are checked against the manifest and their payload against the
+// header on the way, a corrupt copy fails the save and the next source is
+// tried.
+func (b *Rebalancer) transfer(manifest Manifest, part Part, move Move, source, target string) error {
+	open := func() (io.ReadCloser, error) {
+		reader, err := b.storages.Load(context.Background(), source, move.Name, move.Part, 0)
+		if err != nil {
+			return nil, fmt.Errorf("load from %s: %w", source, err)
+		}
+		checked, err := verified(reader, manifest, part)
+		if err != nil {
+			_ = reader.Close()
+			return nil, fmt.Errorf("load from %s: %w", source, err)
+		}
+		return readCloser{Reader: data.NewThrottleReader(checked, b.rate), Closer: reader}, nil
+	}
+
+	if err := b.storages.Save(context.Background(), target, move.Name, move.Part, "", open, move.Size); err != nil {
+		return fmt.Errorf("save from %s: %w", source, err)
+	}
+
+	return nil
+}
+
+// verified passes the stored part through with its header, failing the read
+// when the header does not match the manifest or the payload its sum. Parts
+// stored before the parts version carry no sum and are passed as they are.
+func verified(reader io.Reader, manifest Manifest, part Part) (io.Reader, error) {
+	if manifest.Version < header.Parts {
+		return reader, nil
+	}
+
+	prefix := &bytes.Buffer{}
+	checker, err := verify(io.TeeReader(reader, prefix), manifest, part)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(prefix, checker.Reader(reader)), nil
+}
+
+func missing(move Move) []string {
+	targets := make([]string, 0, len(move.To))
+	for _, backend := range move.To {
+		if !slices.Contains(move.From, backend) {
+			targets = append(targets, backend)
+		}
+	}
+	return targets
+}
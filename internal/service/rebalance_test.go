@@ -0,0 +1,140 @@
+package service
+
+import (
+	"balancer/pkg/conc"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	spare  = "127.0.0.1:9001"
+	target = "127.0.0.1:9002"
+)
+
+func newRebalancer(storages *fakeStorages, manifests *fakeManifests) *Rebalancer {
+	return NewRebalancer(storages, manifests, NewMembership(storages, 1, 1), conc.NewKeyRWLock(), 0)
+}
+
+// rebalance runs the rebalance to its end.
+func rebalance(t *testing.T, rebalancer *Rebalancer) RebalanceReport {
+	t.Helper()
+
+	_, err := rebalancer.Start()
+	require.NoError(t, err)
+
+	var report RebalanceReport
+	require.Eventually(t, func() bool {
+		report, err = rebalancer.Status()
+		require.NoError(t, err)
+		return report.State != JobDistributing
+	}, time.Second, time.Millisecond)
+	return report
+}
+
+func TestRebalanceMoves(t *testing.T) {
+	storages := newFakeStorages()
+	manifests := newFakeManifests()
+	manifest := storages.store(t, []byte("0123456789"), 4)
+	require.NoError(t, manifests.Put(manifest))
+	storages.locate(target)
+
+	report := rebalance(t, newRebalancer(storages, manifests))
+	assert.Equal(t, JobDone, report.State)
+	assert.Len(t, report.Moves, 3)
+
+	moved, err := manifests.Get("file")
+	require.NoError(t, err)
+	copied := 0
+	for _, part := range moved.Parts {
+		assert.Equal(t, []string{target}, part.Backends)
+		assert.True(t, storages.stored(target, part.Index), "part %d", part.Index)
+		assert.False(t, storages.stored(backend, part.Index), "part %d", part.Index)
+		copied += moved.Stored(part)
+	}
+	assert.Equal(t, copied, report.Copied)
+}
+
+func TestRebalanceResumes(t *testing.T) {
+	storages := newFakeStorages()
+	manifests := newFakeManifests()
+	manifest := storages.store(t, []byte("0123456789"), 4)
+	require.NoError(t, manifests.Put(manifest))
+	storages.locate(target)
+
+	// The copy of part 1 fails, its manifest entry and source stay
+	refused := errors.New("refused")
+	storages.refuses[1] = refused
+	rebalancer := newRebalancer(storages, manifests)
+	report := rebalance(t, rebalancer)
+	assert.Equal(t, JobFailed, report.State)
+
+	moved, err := manifests.Get("file")
+	require.NoError(t, err)
+	assert.Equal(t, []string{target}, moved.Parts[0].Backends)
+	assert.Equal(t, []string{backend}, moved.Parts[1].Backends)
+	assert.True(t, storages.stored(backend, 1))
+	assert.False(t, storages.stored(target, 1))
+
+	// Starting again moves only what is left
+	storages.mu.Lock()
+	delete(storages.refuses, 1)
+	storages.mu.Unlock()
+	report = rebalance(t, rebalancer)
+	assert.Equal(t, JobDone, report.State)
+	require.Len(t, report.Moves, 1)
+	assert.Equal(t, 1, report.Moves[0].Part)
+
+	moved, err = manifests.Get("file")
+	require.NoError(t, err)
+	for _, part := range moved.Parts {
+		assert.Equal(t, []string{target}, part.Backends)
+		assert.True(t, storages.stored(target, part.Index), "part %d", part.Index)
+		assert.False(t, storages.stored(backend, part.Index), "part %d", part.Index)
+	}
+}
+
+func TestRebalanceCorruptSource(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		corrupt []string
+		state   JobState
+	}{
+		{name: "next source", corrupt: []string{backend}, state: JobDone},
+		{name: "every source", corrupt: []string{backend, spare}, state: JobFailed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			storages := newFakeStorages()
+			manifests := newFakeManifests()
+			storages.locate(backend, spare)
+			manifest := storages.store(t, []byte("0123"), 4)
+			require.NoError(t, manifests.Put(manifest))
+			storages.locate(target)
+
+			intact := storages.parts[slot{spare, 0}]
+			for _, source := range tt.corrupt {
+				stored := append([]byte(nil), storages.parts[slot{source, 0}]...)
+				stored[len(stored)-1] ^= 0xff
+				storages.parts[slot{source, 0}] = stored
+			}
+
+			report := rebalance(t, newRebalancer(storages, manifests))
+			assert.Equal(t, tt.state, report.State)
+
+			moved, err := manifests.Get("file")
+			require.NoError(t, err)
+			if tt.state == JobDone {
+				assert.Equal(t, []string{target}, moved.Parts[0].Backends)
+				assert.Equal(t, intact, storages.parts[slot{target, 0}])
+				return
+			}
+			assert.Equal(t, []string{backend, spare}, moved.Parts[0].Backends)
+			assert.False(t, storages.stored(target, 0))
+			assert.True(t, storages.stored(backend, 0))
+			assert.True(t, storages.stored(spare, 0))
+		})
+	}
+}
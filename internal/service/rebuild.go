@@ -0,0 +1,137 @@
+package service
+
+import (
+	"balancer/pkg/data"
+	"balancer/pkg/erasure"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// rebuild restores the data part from offset onward by decoding it from any
+// other parts of the erasure coded file, reading them stripe by stripe.
+func (d *SplitDownload) rebuild(ctx context.Context, manifest Manifest, target, offset int) (r io.ReadCloser, e error) {
+	coder, err := erasure.New(manifest.Data, manifest.Parity)
+	if err != nil {
+		return nil, fmt.Errorf("create coder: %w", err)
+	}
+
+	reader := &rebuildReader{
+		coder:   coder,
+		target:  target,
+		sources: make([]io.ReadCloser, len(manifest.Parts)),
+		left:    manifest.Parts[target].Size - offset,
+	}
+
+	found := 0
+	failures := make([]error, 0, len(manifest.Parts))
+	for i, part := range manifest.Parts {
+		if found == manifest.Data {
+			break
+		}
+		if i == target {
+			continue
+		}
+		source, err := d.shard(ctx, manifest, part, offset)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		reader.sources[i] = source
+		found++
+	}
+
+	if found < manifest.Data {
+		_ = reader.Close()
+		return nil, fmt.Errorf(
+			"rebuild part %d from %d parts: %w",
+			target, found, errors.Join(append(failures, erasure.ErrTooFew)...),
+		)
+	}
+
+	return reader, nil
+}
+
+// shard opens the part as a coding shard, data parts shorter than the shard
+// size are padded with zeros.
+func (d *SplitDownload) shard(ctx context.Context, manifest Manifest, part Part, offset int) (io.ReadCloser, error) {
+	if offset >= part.Size {
+		return io.NopCloser(data.Zeros(manifest.Shard - offset)), nil
+	}
+
+	reader, _, _, err := d.load(ctx, manifest.Name, part, manifest.Prefix(part.Index)+offset, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	padded := io.MultiReader(
+		io.LimitReader(reader, int64(part.Size-offset)),
+		data.Zeros(manifest.Shard-part.Size),
+	)
+	return readCloser{Reader: padded, Closer: reader}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type rebuildReader struct {
+	coder   *erasure.Coder
+	target  int
+	sources []io.ReadCloser
+	left    int
+	shards  [][]byte
+	pending []byte
+}
+
+func (r *rebuildReader) Read(p []byte) (n int, e error) {
+	if len(r.pending) == 0 {
+		if r.left <= 0 {
+			return 0, io.EOF
+		}
+		if err := r.decode(min(stripe, r.left)); err != nil {
+			return 0, err
+		}
+	}
+
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *rebuildReader) decode(size int) error {
+	if r.shards == nil {
+		r.shards = make([][]byte, len(r.sources))
+	}
+
+	for i, source := range r.sources {
+		r.shards[i] = nil
+		if source == nil {
+			continue
+		}
+		r.shards[i] = make([]byte, size)
+		if _, err := io.ReadFull(source, r.shards[i]); err != nil {
+			return fmt.Errorf("read part %d: %w", i, err)
+		}
+	}
+
+	if err := r.coder.ReconstructData(r.shards); err != nil {
+		return fmt.Errorf("decode stripe: %w", err)
+	}
+
+	r.pending = r.shards[r.target]
+	r.left -= size
+	return nil
+}
+
+func (r *rebuildReader) Close() error {
+	failures := make([]error, 0, len(r.sources))
+	for _, source := range r.sources {
+		if source != nil {
+			failures = append(failures, source.Close())
+		}
+	}
+	return errors.Join(failures...)
+}
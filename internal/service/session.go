@@ -0,0 +1,256 @@
+package service
+
+import (
+	"balancer/pkg/conc"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"github.com/lithammer/shortuuid/v4"
+)
+
+var (
+	ErrOffset     = errors.New("offset mismatch")
+	ErrLength     = errors.New("length exceeded")
+	ErrIncomplete = errors.New("upload incomplete")
+)
+
+// Session is a resumable upload, its bytes are appended at the offset
+// until they reach the length.
+type Session struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Digest  string    `json:"digest"`
+	Length  int       `json:"length"`
+	Offset  int       `json:"offset"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Expires time.Time `json:"expires"`
+}
+
+func (s Session) Complete() bool {
+	return s.Offset == s.Length
+}
+
+type Sessions struct {
+	sessions SessionRepository
+	expiry   time.Duration
+	keylock  *conc.KeyLock
+}
+
+// NewSessions expires sessions that received nothing for the expiry.
+func NewSessions(sessions SessionRepository, expiry time.Duration) *Sessions {
+	return &Sessions{
+		sessions: sessions,
+		expiry:   expiry,
+		keylock:  conc.NewKeyLock(),
+	}
+}
+
+func (s *Sessions) Create(name, digest string, length int) (Session, error) {
+	now := time.Now().UTC()
+	session := Session{
+		ID:      shortuuid.New(),
+		Name:    name,
+		Digest:  digest,
+		Length:  length,
+		Created: now,
+		Updated: now,
+		Expires: now.Add(s.expiry),
+	}
+	if err := s.sessions.Put(session); err != nil {
+		return Session{}, fmt.Errorf("put session: %w", err)
+	}
+
+	return session, nil
+}
+
+// Get returns the session unless it expired.
+func (s *Sessions) Get(id string) (Session, error) {
+	session, err := s.sessions.Get(id)
+	if err != nil {
+		return Session{}, err
+	}
+	if time.Now().After(session.Expires) {
+		return Session{}, fmt.Errorf("session %s expired: %w", id, fs.ErrNotExist)
+	}
+
+	return session, nil
+}
+
+// Append writes the bytes at the offset, which has to be the offset of the
+// session. Bytes received before a read error are kept and the returned
+// session tells how many of them there are. Bytes past the length are
+// refused after the ones fitting are kept.
+func (s *Sessions) Append(id string, offset int, r io.Reader) (Session, error) {
+	s.keylock.Lock(id)
+	defer s.keylock.Unlock(id)
+
+	session, err := s.Get(id)
+	if err != nil {
+		return Session{}, err
+	}
+	if offset != session.Offset {
+		return session, fmt.Errorf("offset %d, session offset %d: %w", offset, session.Offset, ErrOffset)
+	}
+
+	n, err := s.sessions.Append(id, offset, r, session.Length-offset)
+	if n > 0 {
+		now := time.Now().UTC()
+		session.Offset += n
+		session.Updated = now
+		session.Expires = now.Add(s.expiry)
+		if perr := s.sessions.Put(session); perr != nil {
+			return session, fmt.Errorf("put session: %w", perr)
+		}
+	}
+	if err != nil {
+		return session, fmt.Errorf("append: %w", err)
+	}
+
+	if session.Complete() {
+		if n, _ := r.Read(make([]byte, 1)); n > 0 {
+			return session, fmt.Errorf("length %d: %w", session.Length, ErrLength)
+		}
+	}
+
+	return session, nil
+}
+
+// Open reads the bytes of a complete session.
+func (s *Sessions) Open(id string) (io.ReadCloser, Session, error) {
+	session, err := s.Get(id)
+	if err != nil {
+		return nil, Session{}, err
+	}
+	if !session.Complete() {
+		return nil, Session{}, fmt.Errorf("%d of %d bytes: %w", session.Offset, session.Length, ErrIncomplete)
+	}
+
+	reader, err := s.sessions.Open(id)
+	if err != nil {
+		return nil, Session{}, fmt.Errorf("open session: %w", err)
+	}
+
+	return reader, session, nil
+}
+
+func (s *Sessions) Delete(id string) error {
+	s.keylock.Lock(id)
+	defer s.keylock.Unlock(id)
+
+	if _, err := s.sessions.Get(id); err != nil {
+		return err
+	}
+	if err := s.sessions.Delete(id); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+
+	return nil
+}
+
+// Collect deletes expired sessions with their partial files.
+func (s *Sessions) Collect() (removed int, e error) {
+	sessions, err := s.sessions.List()
+	if err != nil {
+		return 0, fmt.Errorf("list sessions: %w", err)
+	}
+
+	now := time.Now()
+	for _, session := range sessions {
+		if now.Before(session.Expires) {
+			continue
+		}
+
+		expired, err := s.expire(session.ID, now)
+		if err != nil {
+			e = errors.Join(e, fmt.Errorf("delete session %s: %w", session.ID, err))
+			continue
+		}
+		if expired {
+			removed++
+		}
+	}
+
+	return removed, e
+}
+
+// expire deletes the session when it is still expired under its lock, an
+// append may have extended it since it was listed.
+func (s *Sessions) expire(id string, now time.Time) (bool, error) {
+	s.keylock.Lock(id)
+	defer s.keylock.Unlock(id)
+
+	session, err := s.sessions.Get(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if now.Before(session.Expires) {
+		return false, nil
+	}
+
+	if err := s.sessions.Delete(id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Sweeper collects expired sessions periodically.
+type Sweeper struct {
+	sessions *Sessions
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func Sweep(sessions *Sessions, interval time.Duration) *Sweeper {
+	w := &Sweeper{
+		sessions: sessions,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go w.run()
+	return w
+}
+
+func (w *Sweeper) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			removed, err := w.sessions.Collect()
+			if err != nil {
+				slog.Error("collect sessions", "error", err)
+			}
+			if removed > 0 {
+				slog.Info("sessions expired", "removed", removed)
+			}
+		}
+	}
+}
+
+func (w *Sweeper) Close(ctx context.Context) error {
+	close(w.stop)
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"balancer/pkg/disk"
+	"balancer/pkg/header"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidPart = errors.New("invalid part")
+
+type DiskStatus struct {
+	Ready bool   `json:"ready"`
+	Free  int    `json:"free"`
+	Total int    `json:"total"`
+	Error string `json:"error,omitempty"`
+}
+
+type Shelf struct {
+	files   FileRepository
+	index   IndexRepository
+	reserve int
+	mu      sync.Mutex
+}
+
+// NewShelf reports being ready while the dir is writable and has at least
+// reserve bytes free.
+func NewShelf(files FileRepository, index IndexRepository, reserve int) *Shelf {
+	return &Shelf{
+		files:   files,
+		index:   index,
+		reserve: reserve,
+	}
+}
+
+func (s *Shelf) Status() DiskStatus {
+	usage, err := s.files.Usage()
+	if err != nil {
+		return DiskStatus{Error: err.Error()}
+	}
+
+	status := DiskStatus{
+		Ready: usage.Free >= s.reserve,
+		Free:  usage.Free,
+		Total: usage.Total,
+	}
+	if !status.Ready {
+		status.Error = fmt.Sprintf("%d bytes free, %d reserved", usage.Free, s.reserve)
+	}
+	return status
+}
+
+func (s *Shelf) Size() (disk.Size, error) {
+	return s.files.Size()
+}
+
+// Write stores the part. Parts starting with a header are verified against
+// it and the stored file against the digest when it is given, so truncated
+// or corrupt parts are never stored. The file is spilled aside while it is
+// received and only takes the place of its hash together with the index
+// entry, so a concurrent release of the same hash cannot remove it between.
+func (s *Shelf) Write(r io.Reader, name, digest string) (hash string, size int, e error) {
+	reader, err := s.verify(r)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %w", ErrInvalidPart, err)
+	}
+
+	hasher := sha256.New()
+	spilled, size, err := s.files.Spill(io.TeeReader(reader, hasher))
+	if errors.Is(err, header.ErrCorrupt) {
+		return "", 0, fmt.Errorf("%w: %w", ErrInvalidPart, err)
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("write file: %w", err)
+	}
+
+	hash = hex.EncodeToString(hasher.Sum(nil))
+	if digest != "" && !strings.EqualFold(hash, digest) {
+		s.files.Remove(spilled)
+		return "", 0, fmt.Errorf("%w: hash %s, digest %s", ErrInvalidPart, hash, digest)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.files.Export(spilled, hash); err != nil {
+		s.files.Remove(spilled)
+		return "", 0, fmt.Errorf("place file: %w", err)
+	}
+
+	was, err := s.index.Get(name)
+	if err != nil {
+		was = ""
+	}
+	if err := s.index.Put(name, hash); err != nil {
+		s.release(hash)
+		return "", 0, fmt.Errorf("index file: %w", err)
+	}
+	if was != "" && was != hash {
+		s.release(was)
+	}
+
+	return hash, size, nil
+}
+
+// verify wraps the part reader with the check of its header, parts stored
+// before headers existed are passed as is.
+func (s *Shelf) verify(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	prefix, _ := buffered.Peek(header.Header{Version: header.Parts}.Len())
+	if !header.Has(prefix) {
+		return buffered, nil
+	}
+
+	h, err := header.Read(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	return io.MultiReader(
+		bytes.NewReader(bytes.Clone(prefix)),
+		header.NewChecker(h).Reader(buffered),
+	), nil
+}
+
+func (s *Shelf) Read(name string) (r io.ReadSeekCloser, hash string, e error) {
+	hash, err := s.index.Get(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("lookup file: %w", err)
+	}
+
+	reader, err := s.files.Read(hash)
+	if err != nil {
+		return nil, "", fmt.Errorf("read file: %w", err)
+	}
+
+	return reader, hash, nil
+}
+
+func (s *Shelf) Remove(name string) (e error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.index.Get(name)
+	if err != nil {
+		return fmt.Errorf("lookup file: %w", err)
+	}
+	if err := s.index.Delete(name); err != nil {
+		return fmt.Errorf("unindex file: %w", err)
+	}
+	s.release(hash)
+
+	return nil
+}
+
+// release removes the file once no name refers to its hash anymore.
+// Identical parts share a single file on disk.
+func (s *Shelf) release(hash string) {
+	if s.index.Refs(hash) == 0 {
+		s.files.Remove(hash)
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"balancer/pkg/errs"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sync"
+)
+
+type SplitDelete struct {
+	storages  StorageRepository
+	manifests ManifestRepository
+}
+
+func NewSplitDelete(
+	storages StorageRepository,
+	manifests ManifestRepository,
+) *SplitDelete {
+	d := &SplitDelete{
+		storages:  storages,
+		manifests: manifests,
+	}
+	return d
+}
+
+// Delete removes every replica of every part, both where the manifest
+// placed them and where the hasher would place them now. The manifest is
+// kept until all of them are gone, so a failed delete can be repeated.
+func (d *SplitDelete) Delete(name string) (e error) {
+	manifest, err := d.manifests.Get(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		manifest, err = d.probe(name)
+	}
+	if err != nil {
+		return fmt.Errorf("get manifest: %w", err)
+	}
+
+	mu := sync.Mutex{}
+	failures := make([]error, 0)
+	wg := sync.WaitGroup{}
+	placed := place(d.storages, manifest)
+	for i, part := range manifest.Parts {
+		backends := slices.Clone(part.Backends)
+		for _, backend := range placed[i] {
+			if !slices.Contains(backends, backend) {
+				backends = append(backends, backend)
+			}
+		}
+
+		for _, backend := range backends {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := d.storages.Delete(backend, name, part.Index)
+				if err == nil || errors.Is(err, fs.ErrNotExist) {
+					return
+				}
+				mu.Lock()
+				failures = append(failures, fmt.Errorf("delete part %d on %s: %w", part.Index, backend, err))
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+
+	if err := errors.Join(failures...); err != nil {
+		return err
+	}
+
+	if err := d.manifests.Delete(name); err != nil {
+		return fmt.Errorf("delete manifest: %w", err)
+	}
+
+	return nil
+}
+
+// probe reads the header prepended to the first part.
+func (d *SplitDelete) probe(name string) (m Manifest, e error) {
+	failures := make([]error, 0)
+	for _, backend := range d.storages.Locate(name, 0) {
+		reader, err := d.storages.Load(context.Background(), backend, name, 0, 0)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		defer errs.Close(&e, reader.Close)
+
+		manifest, _, err := probed(name, reader)
+		return manifest, err
+	}
+
+	return Manifest{}, fmt.Errorf("load part 0: %w", errors.Join(failures...))
+}
@@ -0,0 +1,403 @@
+package service
+
+import (
+	"balancer/pkg/errs"
+	"balancer/pkg/header"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrRange = errors.New("range out of file")
+
+type SplitDownload struct {
+	storages  StorageRepository
+	manifests ManifestRepository
+	files     FileRepository
+	hedge     time.Duration
+	window    int
+}
+
+// NewSplitDownload asks the next replica of a part when the previous one
+// has not responded within the hedge delay, zero disables hedging. Up to
+// window parts are fetched at once, parts ahead of the one being read are
+// spilled to files, one part at a time is fetched without them.
+func NewSplitDownload(
+	storages StorageRepository,
+	manifests ManifestRepository,
+	files FileRepository,
+	hedge time.Duration,
+	window int,
+) *SplitDownload {
+	d := &SplitDownload{
+		storages:  storages,
+		manifests: manifests,
+		files:     files,
+		hedge:     hedge,
+		window:    window,
+	}
+	return d
+}
+
+// Download opens the file parts in order. Files uploaded before manifests
+// existed are located by probing the first part for its header, their
+// manifest only carries the layout.
+func (d *SplitDownload) Download(name string) (r io.ReadCloser, m Manifest, e error) {
+	manifest, err := d.manifests.Get(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return d.probe(name)
+	}
+	if err != nil {
+		return nil, Manifest{}, fmt.Errorf("get manifest: %w", err)
+	}
+
+	if d.window > 1 && len(manifest.Chunks()) > 1 {
+		reader, err := d.ordered(manifest, manifest.Chunks(), 0)
+		if err != nil {
+			return nil, Manifest{}, err
+		}
+		return reader, manifest, nil
+	}
+
+	reader := d.reader(manifest)
+	if err := reader.open(0); err != nil {
+		return nil, Manifest{}, err
+	}
+
+	return reader, manifest, nil
+}
+
+// Range opens length bytes of the file from offset, only the parts holding
+// them are loaded. The part the range starts in is read from the offset in
+// it, so its header is not checked, lost parts are rebuilt from there.
+func (d *SplitDownload) Range(manifest Manifest, offset, length int) (r io.ReadCloser, e error) {
+	if offset < 0 || length < 0 || offset+length > manifest.Size {
+		return nil, fmt.Errorf("range %d+%d of %d bytes: %w", offset, length, manifest.Size, ErrRange)
+	}
+
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	end := offset + length
+	parts := slices.DeleteFunc(slices.Clone(manifest.Chunks()), func(part Part) bool {
+		return part.Offset+part.Size <= offset || part.Offset >= end
+	})
+
+	var reader io.ReadCloser
+	if d.window > 1 && len(parts) > 1 {
+		ordered, err := d.ordered(manifest, parts, offset-parts[0].Offset)
+		if err != nil {
+			return nil, err
+		}
+		reader = ordered
+	} else {
+		sequential := d.reader(manifest)
+		sequential.parts = parts
+		if err := sequential.open(offset - parts[0].Offset); err != nil {
+			return nil, err
+		}
+		reader = sequential
+	}
+
+	return readCloser{Reader: io.LimitReader(reader, int64(length)), Closer: reader}, nil
+}
+
+func (d *SplitDownload) probe(name string) (r io.ReadCloser, m Manifest, e error) {
+	backends := d.storages.Locate(name, 0)
+	first, backend, _, err := d.load(context.Background(), name, Part{Index: 0, Backends: backends}, 0, "", nil)
+	if err != nil {
+		return nil, Manifest{}, err
+	}
+
+	manifest, h, err := probed(name, first)
+	if err != nil {
+		errs.Close(&err, first.Close)
+		return nil, Manifest{}, err
+	}
+	reader := d.reader(manifest)
+	reader.current, reader.backend = first, backend
+	if h.Version >= header.Parts {
+		reader.check = header.NewChecker(h)
+	}
+
+	return reader, manifest, nil
+}
+
+// probed builds the manifest of a file uploaded before manifests existed
+// from the header of its first part, parts are located by the hasher.
+func probed(name string, first io.Reader) (Manifest, header.Header, error) {
+	h, err := header.Read(first)
+	if err != nil {
+		return Manifest{}, header.Header{}, fmt.Errorf("read header: %w", err)
+	}
+
+	manifest := Manifest{
+		Name:    name,
+		Size:    h.Size,
+		Chunk:   h.Chunk,
+		Version: h.Version,
+		Parts:   make([]Part, h.Count),
+	}
+	for i := range manifest.Parts {
+		manifest.Parts[i] = Part{Index: i}
+	}
+	return manifest, h, nil
+}
+
+// verify reads the part header and compares it with the manifest, the
+// returned checker verifies the payload read after it.
+func verify(reader io.Reader, manifest Manifest, part Part) (*header.Checker, error) {
+	h, err := header.Read(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read part %d header: %w", part.Index, err)
+	}
+	if h.Index != part.Index ||
+		manifest.Digest != "" && (h.Digest != manifest.Digest || h.Length != part.Size) ||
+		part.Digest != "" && h.Sum != part.Digest {
+		return nil, fmt.Errorf("part %d header does not match manifest: %w", part.Index, header.ErrCorrupt)
+	}
+
+	return header.NewChecker(h), nil
+}
+
+func (d *SplitDownload) reader(manifest Manifest) *partsReader {
+	return &partsReader{
+		ctx:      context.Background(),
+		download: d,
+		manifest: manifest,
+		parts:    manifest.Chunks(),
+	}
+}
+
+// load opens the stored part at the given offset from the first replica
+// that responds and passes the check, skipping the one that already failed.
+// With hedging the next replica is asked as well when the previous one does
+// not respond in time, the slower responses are dropped.
+func (d *SplitDownload) load(
+	ctx context.Context,
+	name string,
+	part Part,
+	offset int,
+	skip string,
+	check func(io.Reader) (*header.Checker, error),
+) (r io.ReadCloser, backend string, c *header.Checker, e error) {
+	backends := part.Backends
+	if len(backends) == 0 {
+		backends = d.storages.Locate(name, part.Index)
+	}
+	backends = slices.DeleteFunc(slices.Clone(backends), func(b string) bool {
+		return b == skip
+	})
+	if len(backends) == 0 {
+		return nil, "", nil, fmt.Errorf("load part %d: no other replica", part.Index)
+	}
+
+	results := make(chan loaded, len(backends))
+	launch := func(backend string) {
+		go func() {
+			results <- d.try(ctx, name, part.Index, offset, backend, check)
+		}()
+	}
+
+	var timer *time.Timer
+	if d.hedge > 0 {
+		timer = time.NewTimer(d.hedge)
+		defer timer.Stop()
+	}
+
+	launch(backends[0])
+	return d.race(part.Index, backends, results, launch, timer)
+}
+
+// race waits for the launched loads, launching the next replica on every
+// failure and on every hedge timer tick.
+func (d *SplitDownload) race(
+	index int,
+	backends []string,
+	results chan loaded,
+	launch func(string),
+	timer *time.Timer,
+) (r io.ReadCloser, backend string, c *header.Checker, e error) {
+	var hedge <-chan time.Time
+	if timer != nil {
+		hedge = timer.C
+	}
+
+	launched := 1
+	failures := make([]error, 0, len(backends))
+	for len(failures) < launched {
+		select {
+		case <-hedge:
+			if launched < len(backends) {
+				launch(backends[launched])
+				launched++
+				timer.Reset(d.hedge)
+			}
+		case result := <-results:
+			if result.err == nil {
+				go drain(results, launched-len(failures)-1)
+				return result.reader, result.backend, result.checker, nil
+			}
+			failures = append(failures, result.err)
+			if launched < len(backends) {
+				launch(backends[launched])
+				launched++
+			}
+		}
+	}
+
+	return nil, "", nil, fmt.Errorf("load part %d: %w", index, errors.Join(failures...))
+}
+
+type loaded struct {
+	reader  io.ReadCloser
+	backend string
+	checker *header.Checker
+	err     error
+}
+
+func (d *SplitDownload) try(
+	ctx context.Context,
+	name string,
+	index, offset int,
+	backend string,
+	check func(io.Reader) (*header.Checker, error),
+) loaded {
+	reader, err := d.storages.Load(ctx, backend, name, index, offset)
+	if err != nil {
+		return loaded{err: err}
+	}
+	if check == nil {
+		return loaded{reader: reader, backend: backend}
+	}
+
+	checker, err := check(reader)
+	if err != nil {
+		_ = reader.Close()
+		return loaded{err: fmt.Errorf("check on %s: %w", backend, err)}
+	}
+	return loaded{reader: reader, backend: backend, checker: checker}
+}
+
+// drain closes the readers of the loads that lost the race.
+func drain(results <-chan loaded, pending int) {
+	for range pending {
+		if result := <-results; result.err == nil {
+			_ = result.reader.Close()
+		}
+	}
+}
+
+// partsReader concatenates the parts, switching to another replica at the
+// same offset when the current one fails. Lost parts of erasure coded files
+// are rebuilt from the remaining ones. Parts with headers are verified
+// against them, the check goes on across replicas.
+type partsReader struct {
+	ctx      context.Context
+	download *SplitDownload
+	manifest Manifest
+	parts    []Part
+	part     int
+	offset   int
+	backend  string
+	current  io.ReadCloser
+	check    *header.Checker
+}
+
+func (r *partsReader) Read(p []byte) (n int, e error) {
+	for r.part < len(r.parts) {
+		if r.current == nil {
+			if err := r.open(r.offset); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.current.Read(p)
+		r.offset += n
+		if r.check != nil {
+			if _, cerr := r.check.Write(p[:n]); cerr != nil {
+				return n, cerr
+			}
+		}
+		switch {
+		case err == io.EOF && r.check != nil:
+			err = r.check.Verify()
+			if err == nil {
+				err = r.next()
+			}
+		case err == io.EOF:
+			err = r.next()
+		case err != nil:
+			slog.Error("read part", "part", r.part, "backend", r.backend, "error", err)
+			_ = r.current.Close()
+			r.current = nil
+			err = r.open(r.offset)
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+
+	return 0, io.EOF
+}
+
+func (r *partsReader) open(offset int) error {
+	part := r.parts[r.part]
+	at := r.manifest.Prefix(part.Index) + offset
+	var check func(io.Reader) (*header.Checker, error)
+	if offset == 0 && r.manifest.Version >= header.Parts {
+		at = 0
+		check = func(reader io.Reader) (*header.Checker, error) {
+			return verify(reader, r.manifest, part)
+		}
+	}
+
+	reader, backend, checker, err := r.download.load(r.ctx, r.manifest.Name, part, at, r.backend, check)
+	if checker != nil {
+		r.check = checker
+	}
+	if err != nil && r.manifest.Parity > 0 {
+		slog.Error("rebuild part", "part", part.Index, "error", err)
+		reader, err = r.download.rebuild(r.ctx, r.manifest, part.Index, offset)
+		backend = ""
+		if offset == 0 && r.manifest.Version >= header.Parts {
+			r.check = header.NewChecker(header.Header{Length: part.Size, Sum: part.Digest})
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	r.current, r.backend, r.offset = reader, backend, offset
+	return nil
+}
+
+func (r *partsReader) next() error {
+	err := r.current.Close()
+	r.current = nil
+	r.backend = ""
+	r.offset = 0
+	r.check = nil
+	r.part++
+	if err != nil {
+		return fmt.Errorf("close part %d: %w", r.part-1, err)
+	}
+	return nil
+}
+
+func (r *partsReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	r.part = len(r.parts)
+	return err
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRange(t *testing.T) {
+	payload := []byte("0123456789abcdefghij")
+
+	for _, tt := range []struct {
+		name   string
+		offset int
+		length int
+		want   string
+		parts  []int
+	}{
+		{name: "whole file", offset: 0, length: 20, want: string(payload), parts: []int{0, 1, 2, 3, 4}},
+		{name: "inside a part", offset: 5, length: 2, want: "56", parts: []int{1}},
+		{name: "from mid part across parts", offset: 2, length: 11, want: "23456789abc", parts: []int{0, 1, 2, 3}},
+		{name: "ending on a part boundary", offset: 3, length: 5, want: "34567", parts: []int{0, 1}},
+		{name: "starting on a part boundary", offset: 8, length: 4, want: "89ab", parts: []int{2}},
+		{name: "last byte", offset: 19, length: 1, want: "j", parts: []int{4}},
+		{name: "zero length", offset: 7, length: 0, want: "", parts: nil},
+		{name: "zero length at the end", offset: 20, length: 0, want: "", parts: nil},
+	} {
+		for _, window := range []int{1, 4} {
+			t.Run(tt.name, func(t *testing.T) {
+				storages := newFakeStorages()
+				manifest := storages.store(t, payload, 4)
+				download := NewSplitDownload(storages, nil, newFakeFiles(), 0, window)
+
+				reader, err := download.Range(manifest, tt.offset, tt.length)
+				require.NoError(t, err)
+				got, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				require.NoError(t, reader.Close())
+
+				assert.Equal(t, tt.want, string(got), "window %d", window)
+				assert.Equal(t, tt.parts, slices.Sorted(slices.Values(storages.loaded())), "window %d", window)
+			})
+		}
+	}
+}
+
+func TestRangeOutOfFile(t *testing.T) {
+	storages := newFakeStorages()
+	manifest := storages.store(t, []byte("0123456789"), 4)
+	download := NewSplitDownload(storages, nil, newFakeFiles(), 0, 1)
+
+	for _, r := range [][2]int{{8, 3}, {-1, 2}, {2, -1}, {11, 0}} {
+		_, err := download.Range(manifest, r[0], r[1])
+		assert.ErrorIs(t, err, ErrRange, "range %d+%d", r[0], r[1])
+	}
+	assert.Empty(t, storages.loaded())
+}
+
+func TestDownloadWithoutManifest(t *testing.T) {
+	payload := []byte("0123456789")
+	storages := newFakeStorages()
+	stored := storages.store(t, payload, 4)
+	download := NewSplitDownload(storages, newFakeManifests(), newFakeFiles(), 0, 1)
+
+	reader, manifest, err := download.Download("file")
+	require.NoError(t, err)
+	got, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	assert.Equal(t, string(payload), string(got))
+	assert.Equal(t, stored.Size, manifest.Size)
+	assert.Equal(t, stored.Chunk, manifest.Chunk)
+	assert.Len(t, manifest.Parts, len(stored.Parts))
+}
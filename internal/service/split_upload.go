@@ -2,74 +2,535 @@ package service
 
 import (
 	"balancer/pkg/data"
+	"balancer/pkg/erasure"
 	"balancer/pkg/errs"
+	"balancer/pkg/header"
+	"balancer/pkg/trace"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"slices"
+	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
 
+const stripe = 64 * 1024
+
+var ErrSpread = errors.New("fewer storages than parts of a stripe")
+
 type SplitUpload struct {
-	files    FileRepository
-	storages StorageRepository
+	files     FileRepository
+	storages  StorageRepository
+	manifests ManifestRepository
+	quorum    int
+	chunk     int
+	count     int
+	data      int
+	parity    int
+	through   bool
 }
 
+// NewSplitUpload cuts files into chunks of a fixed size, or into count parts
+// when it is set, regardless of the number of backends. When parity is set
+// files are cut into data parts and erasure coded instead. With through set
+// files are sent while they are received when possible.
 func NewSplitUpload(
 	files FileRepository,
 	storages StorageRepository,
+	manifests ManifestRepository,
+	quorum int,
+	chunk int,
+	count int,
+	data int,
+	parity int,
+	through bool,
 ) *SplitUpload {
 	u := &SplitUpload{
-		files:    files,
-		storages: storages,
+		files:     files,
+		storages:  storages,
+		manifests: manifests,
+		quorum:    quorum,
+		chunk:     chunk,
+		count:     count,
+		data:      data,
+		parity:    parity,
+		through:   through,
 	}
 	return u
 }
 
-func (u *SplitUpload) Upload(name, hash string, size int) {
+func (u *SplitUpload) Upload(ctx context.Context, name, hash string, size int, job *Job) (e error) {
 	defer u.files.Remove(hash)
 
-	backends := u.storages.Backends()
-	average := size / backends
-	smaller := data.PrevPowerOfTwo(int(average))
-	remains := size - smaller*backends
+	ctx, span := trace.Start(ctx, "distribute")
+	defer func() {
+		span.Fail(e)
+		span.Finish()
+	}()
+	span.Set("name", name)
+	span.Set("size", size)
+
+	manifest := Manifest{
+		Name:     name,
+		Digest:   hash,
+		Size:     size,
+		Backends: u.storages.Backends(),
+		Version:  header.Version,
+	}
+	sources := make([]source, 0)
+
+	if u.parity > 0 {
+		manifest.Data, manifest.Parity = u.data, u.parity
+		manifest.Shard, manifest.Parts = shard(size, u.data, u.parity)
+
+		_, encoding := trace.Start(ctx, "encode parity")
+		parities, err := u.encode(hash, manifest)
+		encoding.Fail(err)
+		encoding.Finish()
+		defer func() {
+			for _, parity := range parities {
+				if parity != "" {
+					u.files.Remove(parity)
+				}
+			}
+		}()
+		if err != nil {
+			return fmt.Errorf("encode parity: %w", err)
+		}
+		for _, part := range manifest.Chunks() {
+			sources = append(sources, source{file: hash, at: part.Offset})
+		}
+		for _, parity := range parities {
+			sources = append(sources, source{file: parity})
+		}
+	} else {
+		manifest.Chunk, manifest.Parts = u.layout(size)
+		for _, part := range manifest.Parts {
+			sources = append(sources, source{file: hash, at: part.Offset})
+		}
+	}
+
+	parts := manifest.Parts
+	for i, backends := range place(u.storages, manifest) {
+		parts[i].Backends = backends
+	}
+	if spread := distinct(parts); u.parity > 0 && spread < len(parts) {
+		return fmt.Errorf("spread %d parts over %d storages: %w", len(parts), spread, ErrSpread)
+	}
+	job.Distribute(parts)
+	span.Set("parts", len(parts))
 
+	layout := header.Header{
+		Version: manifest.Version,
+		Count:   len(manifest.Chunks()),
+		Chunk:   manifest.Chunk,
+		Size:    manifest.Size,
+		Digest:  hash,
+	}
+
+	// Parts no longer follow the backend count, so a file cut into many of
+	// them is sent about one part per storage at a time
 	group := &errgroup.Group{}
-	for part := 0; part < backends; part++ {
-		group.Go(u.stream(name, hash, part, backends, smaller, remains))
+	group.SetLimit(max(len(u.storages.Backends()), 1))
+	for i := range parts {
+		group.Go(u.replicate(ctx, name, sources[i], &parts[i], layout, job))
 	}
 
 	if err := group.Wait(); err != nil {
-		slog.Error("upload", "hash", hash, "error", err)
-		return
+		return fmt.Errorf("distribute parts: %w", err)
+	}
+
+	if err := u.record(manifest); err != nil {
+		return fmt.Errorf("record manifest: %w", err)
 	}
 
 	slog.Info("uploaded", "name", name, "hash", hash)
+	return nil
 }
 
-func (u *SplitUpload) stream(name, hash string, part, backends, smaller, remains int) func() error {
-	return func() (e error) {
-		offset := part * smaller
-		reader, err := u.files.Seek(hash, offset)
+// place locates the parts of the manifest. The parts of an erasure coded
+// stripe are spread over distinct storages, so losing one storage loses one
+// part of it at most, other parts are located on their own.
+func place(storages StorageRepository, manifest Manifest) [][]string {
+	if manifest.Parity > 0 {
+		return storages.Spread(manifest.Name, len(manifest.Parts))
+	}
+
+	placed := make([][]string, len(manifest.Parts))
+	for i := range placed {
+		placed[i] = storages.Locate(manifest.Name, i)
+	}
+	return placed
+}
+
+// distinct counts the storages holding the first replicas of the parts.
+func distinct(parts []Part) int {
+	seen := make(map[string]bool)
+	for _, part := range parts {
+		if len(part.Backends) > 0 {
+			seen[part.Backends[0]] = true
+		}
+	}
+	return len(seen)
+}
+
+// encode streams data parts padded with zeros to the shard size and writes
+// parity parts to separate files. Their hashes are returned in order.
+func (u *SplitUpload) encode(hash string, manifest Manifest) (parities []string, e error) {
+	coder, err := erasure.New(manifest.Data, manifest.Parity)
+	if err != nil {
+		return nil, fmt.Errorf("create coder: %w", err)
+	}
+
+	readers := make([]io.Reader, manifest.Data)
+	for i, part := range manifest.Chunks() {
+		reader, err := u.files.Seek(hash, part.Offset)
 		if err != nil {
-			return fmt.Errorf("seek offset %d: %w", offset, err)
+			return nil, fmt.Errorf("seek offset %d: %w", part.Offset, err)
 		}
 		defer errs.Close(&e, reader.Close)
+		readers[i] = io.MultiReader(
+			io.LimitReader(reader, int64(part.Size)),
+			data.Zeros(manifest.Shard-part.Size),
+		)
+	}
+
+	parities = make([]string, manifest.Parity)
+	writers := make([]*io.PipeWriter, manifest.Parity)
+	// Every stripe is written to all the pipes in turn, so each of them needs
+	// its reader running, the parity parts bound the group instead of a limit
+	group := &errgroup.Group{}
+	for i := range writers {
+		reader, writer := io.Pipe()
+		writers[i] = writer
+		group.Go(func() error {
+			hash, _, err := u.files.Write(reader)
+			reader.CloseWithError(err)
+			parities[i] = hash
+			return err
+		})
+	}
+
+	err = u.stripes(coder, readers, writers, manifest.Shard)
+	for _, writer := range writers {
+		writer.CloseWithError(err)
+	}
+	if err := errors.Join(err, group.Wait()); err != nil {
+		return parities, err
+	}
+
+	return parities, nil
+}
+
+func (u *SplitUpload) stripes(coder *erasure.Coder, readers []io.Reader, writers []*io.PipeWriter, shard int) error {
+	shards := make([][]byte, coder.Data()+coder.Parity())
+	for i := range shards {
+		shards[i] = make([]byte, stripe)
+	}
+
+	for done := 0; done < shard; done += stripe {
+		n := min(stripe, shard-done)
+		blocks := make([][]byte, len(shards))
+		for i := range shards {
+			blocks[i] = shards[i][:n]
+		}
 
-		limit := smaller
-		if part == backends-1 {
-			limit = remains
+		for i, reader := range readers {
+			if _, err := io.ReadFull(reader, blocks[i]); err != nil {
+				return fmt.Errorf("read part %d: %w", i, err)
+			}
 		}
-		var prepend []byte
-		if part == 0 {
-			prepend = []byte{byte(backends)}
-			limit++
+		if err := coder.Encode(blocks); err != nil {
+			return fmt.Errorf("encode stripe: %w", err)
 		}
-		combined := io.MultiReader(bytes.NewReader(prepend), reader)
-		if err := u.storages.Save(name, part, combined, limit); err != nil {
-			return fmt.Errorf("save on storage %d: %w", offset, err)
+		for i, writer := range writers {
+			if _, err := writer.Write(blocks[len(readers)+i]); err != nil {
+				return fmt.Errorf("write parity %d: %w", i, err)
+			}
 		}
+	}
+
+	return nil
+}
+
+// source is the temp file holding the part payload at the offset.
+type source struct {
+	file string
+	at   int
+}
+
+// replicate streams the part to every replica at once and succeeds when
+// the quorum of them acknowledged it. Only acknowledged replicas are kept
+// in the part placement.
+func (u *SplitUpload) replicate(
+	ctx context.Context,
+	name string,
+	source source,
+	part *Part,
+	layout header.Header,
+	job *Job,
+) func() error {
+	return func() (e error) {
+		ctx, span := trace.Start(ctx, "replicate part")
+		defer func() {
+			job.Complete(part.Index, e)
+			span.Fail(e)
+			span.Finish()
+		}()
+		span.Set("part", part.Index)
+		span.Set("size", part.Size)
+
+		prefix, stored, err := u.prepare(ctx, source, part, layout)
+		if err != nil {
+			return err
+		}
+
+		failures := make([]error, len(part.Backends))
+		wg := sync.WaitGroup{}
+		for i, backend := range part.Backends {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				failures[i] = u.stream(ctx, backend, name, source, *part, prefix, stored, job)
+			}()
+		}
+		wg.Wait()
+
+		acked := make([]string, 0, len(part.Backends))
+		for i, backend := range part.Backends {
+			if failures[i] != nil {
+				slog.Error("replicate", "backend", backend, "part", part.Index, "error", failures[i])
+				continue
+			}
+			acked = append(acked, backend)
+		}
+		if len(acked) < u.quorum {
+			return fmt.Errorf(
+				"part %d acknowledged by %d replicas, quorum is %d: %w",
+				part.Index, len(acked), u.quorum, errors.Join(failures...),
+			)
+		}
+		part.Backends = acked
+
 		return nil
 	}
 }
+
+// prepare hashes the part payload for its header and the part with the
+// header for storages to check.
+func (u *SplitUpload) prepare(
+	ctx context.Context,
+	source source,
+	part *Part,
+	layout header.Header,
+) (prefix []byte, stored string, e error) {
+	_, span := trace.Start(ctx, "hash part")
+	defer func() {
+		span.Fail(e)
+		span.Finish()
+	}()
+	span.Set("part", part.Index)
+
+	sum, err := u.digest(source, *part, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("hash part %d: %w", part.Index, err)
+	}
+	part.Digest = sum
+
+	layout.Index, layout.Length, layout.Sum = part.Index, part.Size, sum
+	prefix, err = layout.MarshalBinary()
+	if err != nil {
+		return nil, "", fmt.Errorf("encode part %d header: %w", part.Index, err)
+	}
+	stored, err = u.digest(source, *part, prefix)
+	if err != nil {
+		return nil, "", fmt.Errorf("hash stored part %d: %w", part.Index, err)
+	}
+
+	return prefix, stored, nil
+}
+
+// digest hashes the part payload preceded by the prefix. The payload hash
+// goes to the part header, the hash with the header is checked by storages.
+func (u *SplitUpload) digest(source source, part Part, prefix []byte) (sum string, e error) {
+	reader, err := u.files.Seek(source.file, source.at)
+	if err != nil {
+		return "", fmt.Errorf("seek offset %d: %w", source.at, err)
+	}
+	defer errs.Close(&e, reader.Close)
+
+	hasher := sha256.New()
+	hasher.Write(prefix)
+	if _, err := io.CopyN(hasher, reader, int64(part.Size)); err != nil {
+		return "", fmt.Errorf("read part: %w", err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// stream sends the part payload prepended with its header, the temp file
+// is seeked again for every attempt.
+func (u *SplitUpload) stream(
+	ctx context.Context,
+	backend, name string,
+	source source,
+	part Part,
+	prefix []byte,
+	digest string,
+	job *Job,
+) error {
+	limit := len(prefix) + part.Size
+	open := func() (io.ReadCloser, error) {
+		reader, err := u.files.Seek(source.file, source.at)
+		if err != nil {
+			return nil, fmt.Errorf("seek offset %d: %w", source.at, err)
+		}
+		combined := data.NewProgressReader(
+			io.MultiReader(bytes.NewReader(prefix), io.LimitReader(reader, int64(part.Size))),
+			limit, job.Progress(part.Index),
+		)
+		return readCloser{Reader: combined, Closer: reader}, nil
+	}
+
+	if err := u.storages.Save(ctx, backend, name, part.Index, digest, open, limit); err != nil {
+		return fmt.Errorf("save on storage %s: %w", backend, err)
+	}
+
+	return nil
+}
+
+func (u *SplitUpload) record(manifest Manifest) error {
+	now := time.Now().UTC()
+	manifest.Created, manifest.Updated = now, now
+
+	was, err := u.manifests.Get(manifest.Name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("get manifest: %w", err)
+	}
+	if err == nil {
+		manifest.Created = was.Created
+	}
+
+	if err := u.manifests.Put(manifest); err != nil {
+		return fmt.Errorf("put manifest: %w", err)
+	}
+	u.prune(manifest.Name, stale(was, manifest))
+
+	return nil
+}
+
+// stale lists the replicas of the previous manifest which the new one does
+// not overwrite, parts past its count or on backends it does not use.
+func stale(was, now Manifest) []Part {
+	parts := make([]Part, 0)
+	for _, part := range was.Parts {
+		var backends []string
+		if part.Index < len(now.Parts) {
+			backends = now.Parts[part.Index].Backends
+		}
+
+		gone := Part{Index: part.Index}
+		for _, backend := range part.Backends {
+			if !slices.Contains(backends, backend) {
+				gone.Backends = append(gone.Backends, backend)
+			}
+		}
+		if len(gone.Backends) > 0 {
+			parts = append(parts, gone)
+		}
+	}
+
+	return parts
+}
+
+// prune deletes the replicas from their storages, failures are only logged
+// since no manifest refers to them anymore.
+func (u *SplitUpload) prune(name string, parts []Part) {
+	wg := sync.WaitGroup{}
+	for _, part := range parts {
+		for _, backend := range part.Backends {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := u.storages.Delete(backend, name, part.Index)
+				if err != nil && !errors.Is(err, fs.ErrNotExist) {
+					slog.Error("prune part", "name", name, "part", part.Index, "backend", backend, "error", err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// layout returns the chunk size and the parts of a file that is not erasure
+// coded.
+func (u *SplitUpload) layout(size int) (int, []Part) {
+	if u.count > 0 {
+		parts := split(size, u.count)
+		return parts[0].Size, parts
+	}
+	return u.chunk, chunk(size, u.chunk)
+}
+
+// chunk cuts size bytes into parts of the chunk size, the last one holds
+// the remaining bytes.
+func chunk(size, chunk int) []Part {
+	count := max(1, (size+chunk-1)/chunk)
+
+	parts := make([]Part, count)
+	for i := range parts {
+		parts[i] = Part{
+			Index:  i,
+			Offset: i * chunk,
+			Size:   min(chunk, size-i*chunk),
+		}
+	}
+
+	return parts
+}
+
+// split cuts size bytes into count parts rounded down to a power of two,
+// the remaining bytes go to the last part.
+func split(size, count int) []Part {
+	average := size / count
+	smaller := data.PrevPowerOfTwo(average)
+	remains := size - smaller*(count-1)
+
+	parts := make([]Part, count)
+	for i := range parts {
+		parts[i] = Part{
+			Index:  i,
+			Offset: i * smaller,
+			Size:   smaller,
+		}
+	}
+	parts[count-1].Size = remains
+
+	return parts
+}
+
+// shard cuts size bytes into data parts of equal size, the last one may be
+// shorter and is padded with zeros for coding. Parity parts are full size
+// and have no offset in the file.
+func shard(size, data, parity int) (int, []Part) {
+	shard := (size + data - 1) / data
+
+	parts := make([]Part, data+parity)
+	for i := range parts {
+		parts[i] = Part{Index: i, Size: shard}
+		if i < data {
+			parts[i].Offset = min(i*shard, size)
+			parts[i].Size = min(shard, size-parts[i].Offset)
+		}
+	}
+
+	return shard, parts
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"balancer/pkg/header"
+	"balancer/pkg/trace"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ahead is how many chunks are spilled and sent while the next one is
+// being received.
+const ahead = 2
+
+var ErrDigest = errors.New("digest mismatch")
+
+// Streams tells whether the file is uploaded while it is received. Erasure
+// coding needs the whole file, and files already stored are not streamed,
+// so a rolled back upload never touches their parts.
+func (u *SplitUpload) Streams(name string) bool {
+	if !u.through || u.parity > 0 {
+		return false
+	}
+
+	_, err := u.manifests.Get(name)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// Stream cuts the body into chunks as it arrives and sends every chunk once
+// it is spilled, hashing the whole body on the way. The part headers carry
+// the digest given by the client, when the body does not match it or the
+// distribution fails, the parts sent already are deleted.
+func (u *SplitUpload) Stream(ctx context.Context, name, digest string, body io.Reader, size int, job *Job) (e error) {
+	ctx, span := trace.Start(ctx, "stream")
+	defer func() {
+		span.Fail(e)
+		span.Finish()
+	}()
+	span.Set("name", name)
+	span.Set("size", size)
+
+	manifest := Manifest{
+		Name:     name,
+		Digest:   digest,
+		Size:     size,
+		Backends: u.storages.Backends(),
+		Version:  header.Version,
+	}
+	manifest.Chunk, manifest.Parts = u.layout(size)
+
+	parts := manifest.Parts
+	placed := make([][]string, len(parts))
+	for i := range parts {
+		parts[i].Backends = u.storages.Locate(name, i)
+		placed[i] = slices.Clone(parts[i].Backends)
+	}
+	job.Distribute(parts)
+	job.Stream()
+	span.Set("parts", len(parts))
+
+	layout := header.Header{
+		Version: manifest.Version,
+		Count:   len(parts),
+		Chunk:   manifest.Chunk,
+		Size:    manifest.Size,
+		Digest:  digest,
+	}
+
+	hasher := sha256.New()
+	reader := io.TeeReader(body, hasher)
+	group, failed := errgroup.WithContext(ctx)
+	group.SetLimit(ahead)
+	received := 0
+	var err error
+	for i := range parts {
+		if failed.Err() != nil {
+			break
+		}
+		var spilled string
+		spilled, err = u.spill(reader, parts[i])
+		if err != nil {
+			break
+		}
+		received++
+
+		replicate := u.replicate(failed, name, source{file: spilled}, &parts[i], layout, job)
+		group.Go(func() error {
+			defer u.files.Remove(spilled)
+			return replicate()
+		})
+	}
+	if werr := group.Wait(); werr != nil {
+		err = errors.Join(err, fmt.Errorf("distribute parts: %w", werr))
+	}
+	if sum := hex.EncodeToString(hasher.Sum(nil)); err == nil && sum != digest {
+		err = fmt.Errorf("hash %s, digest %s: %w", sum, digest, ErrDigest)
+	}
+	if err != nil {
+		u.rollback(name, placed[:received])
+		return err
+	}
+
+	if err := u.record(manifest); err != nil {
+		return fmt.Errorf("record manifest: %w", err)
+	}
+
+	job.Hashed(digest)
+	slog.Info("uploaded", "name", name, "hash", digest)
+	return nil
+}
+
+// spill writes the next chunk of the body to a temp file.
+func (u *SplitUpload) spill(body io.Reader, part Part) (name string, e error) {
+	name, size, err := u.files.Spill(io.LimitReader(body, int64(part.Size)))
+	if err != nil {
+		return "", fmt.Errorf("spill part %d: %w", part.Index, err)
+	}
+	if size != part.Size {
+		u.files.Remove(name)
+		return "", fmt.Errorf("spill part %d: %d of %d bytes: %w", part.Index, size, part.Size, io.ErrUnexpectedEOF)
+	}
+
+	return name, nil
+}
+
+// rollback deletes the parts from every storage they were sent to, except
+// the replicas a recorded manifest of the name refers to. Nothing is deleted
+// when the manifest cannot be read, failures are only logged.
+func (u *SplitUpload) rollback(name string, placed [][]string) {
+	attempt := Manifest{Parts: make([]Part, len(placed))}
+	for i, backends := range placed {
+		attempt.Parts[i] = Part{Index: i, Backends: backends}
+	}
+
+	recorded, err := u.manifests.Get(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("rollback", "name", name, "error", err)
+		return
+	}
+	u.prune(name, stale(attempt, recorded))
+}
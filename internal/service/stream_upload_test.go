@@ -0,0 +1,54 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamRollback(t *testing.T) {
+	payload := []byte("01234567")
+
+	for _, tt := range []struct {
+		name     string
+		recorded []Part
+		deleted  []int
+		kept     []int
+	}{
+		{name: "new file", recorded: nil, deleted: []int{0, 1}, kept: nil},
+		{
+			name:     "recorded meanwhile",
+			recorded: []Part{{Index: 0, Backends: []string{backend}}},
+			deleted:  []int{1},
+			kept:     []int{0},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			storages := newFakeStorages()
+			manifests := newFakeManifests()
+			files := newFakeFiles()
+			upload := NewSplitUpload(files, storages, manifests, 1, 4, 0, 0, 0, true)
+
+			// Part 0 is sent before part 1 is refused
+			refused := errors.New("refused")
+			storages.refuses[1] = refused
+			if tt.recorded != nil {
+				require.NoError(t, manifests.Put(Manifest{Name: "file", Parts: tt.recorded}))
+			}
+
+			job := NewJobs().Create("file")
+			err := upload.Stream(context.Background(), "file", hash(payload), bytes.NewReader(payload), len(payload), job)
+			require.ErrorIs(t, err, refused)
+
+			assert.Equal(t, tt.deleted, storages.deleted())
+			for _, part := range tt.kept {
+				assert.True(t, storages.stored(backend, part), "part %d", part)
+			}
+			assert.Zero(t, files.left())
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package service
 
 import (
+	"balancer/pkg/disk"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 )
 
@@ -16,10 +20,35 @@ func (f *Vault) Write(r io.Reader, name string) (string, int, error) {
 	return f.files.Write(r)
 }
 
-func (f *Vault) Read(hash string) (r io.ReadCloser, e error) {
+// Claim is Write for a body of unknown hash, it is spilled aside and takes
+// the place of its hash only after claim is called with it. A file of the
+// same content still being distributed is not replaced under its upload
+// when claim waits for it.
+func (f *Vault) Claim(r io.Reader, claim func(hash string)) (string, int, error) {
+	hasher := sha256.New()
+	spilled, size, err := f.files.Spill(io.TeeReader(r, hasher))
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	claim(hash)
+	if err := f.files.Export(spilled, hash); err != nil {
+		f.files.Remove(spilled)
+		return "", 0, fmt.Errorf("place file: %w", err)
+	}
+
+	return hash, size, nil
+}
+
+func (f *Vault) Read(hash string) (r io.ReadSeekCloser, e error) {
 	return f.files.Read(hash)
 }
 
 func (f *Vault) Remove(hash string) {
 	f.files.Remove(hash)
 }
+
+func (f *Vault) Size() (disk.Size, error) {
+	return f.files.Size()
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// windowReader streams the parts in order while fetching the parts after
+// the current one concurrently, up to the window of parts at once. Fetched
+// parts are spilled to temp files, so memory does not grow with the part
+// size, and removed once they are read or the reader is closed. Closing the
+// reader cancels the fetches in flight.
+type windowReader struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	download *SplitDownload
+	manifest Manifest
+	parts    []Part
+	window   int
+	head     int
+	current  io.ReadCloser
+	spilled  string
+	fetches  map[int]*fetch
+}
+
+type fetch struct {
+	done chan struct{}
+	name string
+	err  error
+}
+
+// ordered reads the parts from the offset in the first of them.
+func (d *SplitDownload) ordered(manifest Manifest, parts []Part, offset int) (*windowReader, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	first, err := d.chunk(ctx, manifest, parts[0], offset)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	r := &windowReader{
+		ctx:      ctx,
+		cancel:   cancel,
+		download: d,
+		manifest: manifest,
+		parts:    parts,
+		window:   d.window,
+		current:  first,
+		fetches:  make(map[int]*fetch),
+	}
+	r.fill()
+	return r, nil
+}
+
+// chunk opens a single part from the offset in it, with the failover,
+// checks and rebuilding of the parts reader.
+func (d *SplitDownload) chunk(ctx context.Context, manifest Manifest, part Part, offset int) (*partsReader, error) {
+	reader := &partsReader{ctx: ctx, download: d, manifest: manifest, parts: []Part{part}}
+	if err := reader.open(offset); err != nil {
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// fill starts fetching the parts in the window that are not fetched yet.
+func (r *windowReader) fill() {
+	for i := r.head + 1; i < min(r.head+r.window, len(r.parts)); i++ {
+		if _, ok := r.fetches[i]; ok {
+			continue
+		}
+
+		f := &fetch{done: make(chan struct{})}
+		r.fetches[i] = f
+		part := r.parts[i]
+		go func() {
+			defer close(f.done)
+			reader := &partsReader{ctx: r.ctx, download: r.download, manifest: r.manifest, parts: []Part{part}}
+			f.name, _, f.err = r.download.files.Spill(reader)
+			if err := reader.Close(); err != nil && f.err == nil {
+				f.err = err
+			}
+		}()
+	}
+}
+
+func (r *windowReader) Read(p []byte) (n int, e error) {
+	for r.head < len(r.parts) {
+		if r.current == nil {
+			if err := r.open(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			err = r.next()
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+
+	return 0, io.EOF
+}
+
+// open waits for the fetch of the head part and reads its spill file.
+func (r *windowReader) open() error {
+	f := r.fetches[r.head]
+	delete(r.fetches, r.head)
+	<-f.done
+	if f.err != nil {
+		return fmt.Errorf("fetch part %d: %w", r.parts[r.head].Index, f.err)
+	}
+
+	reader, err := r.download.files.Read(f.name)
+	if err != nil {
+		r.download.files.Remove(f.name)
+		return fmt.Errorf("read part %d: %w", r.parts[r.head].Index, err)
+	}
+
+	r.current, r.spilled = reader, f.name
+	return nil
+}
+
+func (r *windowReader) next() error {
+	err := r.release()
+	r.head++
+	r.fill()
+	if err != nil {
+		return fmt.Errorf("close part %d: %w", r.parts[r.head-1].Index, err)
+	}
+	return nil
+}
+
+func (r *windowReader) release() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	if r.spilled != "" {
+		r.download.files.Remove(r.spilled)
+		r.spilled = ""
+	}
+	return err
+}
+
+// Close cancels the fetches and removes the spill files, parts still being
+// fetched are removed once their fetch gives up.
+func (r *windowReader) Close() error {
+	r.cancel()
+	err := r.release()
+	r.head = len(r.parts)
+	for i, f := range r.fetches {
+		delete(r.fetches, i)
+		go func() {
+			<-f.done
+			if f.err == nil {
+				r.download.files.Remove(f.name)
+			}
+		}()
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type read struct {
+	data []byte
+	err  error
+}
+
+func TestWindowOrder(t *testing.T) {
+	payload := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ!?")
+	storages := newFakeStorages()
+	manifest := storages.store(t, payload, 8)
+	files := newFakeFiles()
+	download := NewSplitDownload(storages, nil, files, 0, 4)
+
+	// Part 1 is held until the parts after it were requested, which finish
+	// in reverse order
+	gate := make(chan struct{})
+	storages.gates[1] = gate
+	storages.delays[2] = 30 * time.Millisecond
+	storages.delays[3] = 10 * time.Millisecond
+
+	reader, err := download.Range(manifest, 0, len(payload))
+	require.NoError(t, err)
+	done := make(chan read)
+	go func() {
+		data, err := io.ReadAll(reader)
+		done <- read{data: data, err: err}
+	}()
+
+	assert.Eventually(t, func() bool {
+		loaded := storages.loaded()
+		return slices.Contains(loaded, 2) && slices.Contains(loaded, 3)
+	}, time.Second, time.Millisecond)
+	close(gate)
+
+	got := <-done
+	require.NoError(t, got.err)
+	assert.Equal(t, string(payload), string(got.data))
+	require.NoError(t, reader.Close())
+	assert.Equal(t, 0, files.left())
+}
+
+func TestWindowFailedFetch(t *testing.T) {
+	payload := []byte("0123456789abcdefghijklmn")
+	storages := newFakeStorages()
+	manifest := storages.store(t, payload, 4)
+	files := newFakeFiles()
+	download := NewSplitDownload(storages, nil, files, 0, 4)
+
+	down := errors.New("storage down")
+	storages.fails[3] = down
+
+	reader, err := download.Range(manifest, 0, len(payload))
+	require.NoError(t, err)
+	got, err := io.ReadAll(reader)
+	assert.ErrorIs(t, err, down)
+	assert.ErrorContains(t, err, "fetch part 3")
+	assert.Equal(t, string(payload[:12]), string(got))
+
+	require.NoError(t, reader.Close())
+	assert.Eventually(t, func() bool { return files.left() == 0 }, time.Second, time.Millisecond)
+}
+
+func TestWindowCloseInFlight(t *testing.T) {
+	payload := []byte("0123456789abcdefghijklmn")
+	storages := newFakeStorages()
+	manifest := storages.store(t, payload, 4)
+	files := newFakeFiles()
+	download := NewSplitDownload(storages, nil, files, 0, 4)
+
+	storages.gates[2], storages.gates[3] = make(chan struct{}), make(chan struct{})
+
+	reader, err := download.Range(manifest, 0, len(payload))
+	require.NoError(t, err)
+	head := make([]byte, 4)
+	_, err = io.ReadFull(reader, head)
+	require.NoError(t, err)
+	assert.Equal(t, "0123", string(head))
+
+	// Part 1 is spilled already, parts 2 and 3 are held until the close
+	// cancels them
+	assert.Eventually(t, func() bool { return files.left() == 1 }, time.Second, time.Millisecond)
+	require.NoError(t, reader.Close())
+
+	assert.Eventually(t, func() bool { return slices.Equal(storages.cancelled(), []int{2, 3}) }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return files.left() == 0 }, time.Second, time.Millisecond)
+	files.mu.Lock()
+	defer files.mu.Unlock()
+	assert.Equal(t, 1, files.spilled)
+}
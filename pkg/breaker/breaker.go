@@ -0,0 +1,160 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const smoothing = 0.2
+
+var ErrOpen = errors.New("circuit is open")
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type Stats struct {
+	State     State         `json:"state"`
+	Requests  int           `json:"requests"`
+	Failures  int           `json:"failures"`
+	Latency   time.Duration `json:"latency"`
+	Succeeded time.Time     `json:"succeeded"`
+	Failed    time.Time     `json:"failed"`
+}
+
+type node struct {
+	Stats
+	consecutive int
+	opened      time.Time
+	probing     bool
+}
+
+// Breaker keeps a circuit per key. The circuit opens after the threshold of
+// consecutive failures and lets a single probe through after the cooldown,
+// its outcome closes or opens the circuit again. Zero threshold disables it.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	change    func(key string, state State)
+	mu        sync.Mutex
+	nodes     map[string]*node
+}
+
+// New creates the breaker, change is called on every state change outside
+// of the breaker lock.
+func New(threshold int, cooldown time.Duration, change func(key string, state State)) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		change:    change,
+		nodes:     make(map[string]*node),
+	}
+}
+
+// Allow reports whether a call to the key may go on.
+func (b *Breaker) Allow(key string) bool {
+	b.mu.Lock()
+	n := b.node(key)
+	if n.State == Closed {
+		b.mu.Unlock()
+		return true
+	}
+	if n.State == Open && time.Since(n.opened) >= b.cooldown {
+		n.State = HalfOpen
+	}
+	allow := n.State == HalfOpen && !n.probing
+	if allow {
+		n.probing = true
+	}
+	b.mu.Unlock()
+
+	return allow
+}
+
+// Report records the call outcome and its latency.
+func (b *Breaker) Report(key string, latency time.Duration, failed bool) {
+	b.mu.Lock()
+	n := b.node(key)
+	was := n.State
+
+	n.Requests++
+	if n.Latency == 0 {
+		n.Latency = latency
+	} else {
+		n.Latency = time.Duration(smoothing*float64(latency) + (1-smoothing)*float64(n.Latency))
+	}
+	n.probing = false
+
+	if failed {
+		n.Failures++
+		n.consecutive++
+		n.Failed = time.Now().UTC()
+		if b.threshold > 0 && (n.State == HalfOpen || n.consecutive >= b.threshold) {
+			n.State = Open
+			n.opened = time.Now()
+		}
+	} else {
+		n.consecutive = 0
+		n.Succeeded = time.Now().UTC()
+		n.State = Closed
+	}
+	now := n.State
+	b.mu.Unlock()
+
+	if now != was && b.change != nil && (now == Closed || was == Closed) {
+		b.change(key, now)
+	}
+}
+
+func (b *Breaker) State(key string) State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.node(key).State
+}
+
+func (b *Breaker) Stats() map[string]Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := make(map[string]Stats, len(b.nodes))
+	for key, n := range b.nodes {
+		stats[key] = n.Stats
+	}
+	return stats
+}
+
+// Forget drops the key state.
+func (b *Breaker) Forget(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.nodes, key)
+}
+
+func (b *Breaker) node(key string) *node {
+	n, ok := b.nodes[key]
+	if !ok {
+		n = &node{}
+		b.nodes[key] = n
+	}
+	return n
+}
@@ -0,0 +1,54 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAndRecover(t *testing.T) {
+	changes := make([]State, 0)
+	b := New(3, 20*time.Millisecond, func(key string, state State) {
+		assert.Equal(t, "a", key)
+		changes = append(changes, state)
+	})
+
+	for range 2 {
+		assert.True(t, b.Allow("a"))
+		b.Report("a", time.Millisecond, true)
+	}
+	assert.Equal(t, Closed, b.State("a"))
+
+	b.Report("a", time.Millisecond, true)
+	assert.Equal(t, Open, b.State("a"))
+	assert.False(t, b.Allow("a"))
+	assert.True(t, b.Allow("b"))
+
+	time.Sleep(25 * time.Millisecond)
+	assert.True(t, b.Allow("a"))
+	assert.False(t, b.Allow("a"), "only one probe goes through")
+
+	b.Report("a", time.Millisecond, true)
+	assert.Equal(t, Open, b.State("a"))
+
+	time.Sleep(25 * time.Millisecond)
+	assert.True(t, b.Allow("a"))
+	b.Report("a", time.Millisecond, false)
+	assert.Equal(t, Closed, b.State("a"))
+
+	assert.Equal(t, []State{Open, Closed}, changes)
+
+	stats := b.Stats()["a"]
+	assert.Equal(t, 5, stats.Requests)
+	assert.Equal(t, 4, stats.Failures)
+}
+
+func TestDisabled(t *testing.T) {
+	b := New(0, time.Hour, nil)
+	for range 10 {
+		b.Report("a", time.Millisecond, true)
+	}
+	assert.True(t, b.Allow("a"))
+	assert.Equal(t, Closed, b.State("a"))
+}
@@ -1,6 +1,9 @@
 package conc
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 type KeyLock struct {
 	giantLock sync.RWMutex
@@ -50,6 +53,7 @@ func (l *KeyLock) KeyLocker(key string) sync.Locker {
 type KeyRWLock struct {
 	giantLock sync.RWMutex
 	locks     map[string]*sync.RWMutex
+	holders   atomic.Int64
 }
 
 func NewKeyRWLock() *KeyRWLock {
@@ -82,24 +86,48 @@ func (l *KeyRWLock) getLock(key string) *sync.RWMutex {
 
 func (l *KeyRWLock) Lock(key string) {
 	l.getLock(key).Lock()
+	l.holders.Add(1)
 }
 
 func (l *KeyRWLock) Unlock(key string) {
+	l.holders.Add(-1)
 	l.getLock(key).Unlock()
 }
 
 func (l *KeyRWLock) RLock(key string) {
 	l.getLock(key).RLock()
+	l.holders.Add(1)
 }
 
 func (l *KeyRWLock) RUnlock(key string) {
+	l.holders.Add(-1)
 	l.getLock(key).RUnlock()
 }
 
 func (l *KeyRWLock) KeyLocker(key string) sync.Locker {
-	return l.getLock(key)
+	return keyLocker{lock: l, key: key}
 }
 
 func (l *KeyRWLock) KeyRLocker(key string) sync.Locker {
-	return l.getLock(key).RLocker()
+	return keyRLocker{lock: l, key: key}
+}
+
+// keyLocker takes the key lock through Lock and Unlock, so its holders are
+// counted like the others.
+type keyLocker struct {
+	lock *KeyRWLock
+	key  string
+}
+
+func (k keyLocker) Lock()   { k.lock.Lock(k.key) }
+func (k keyLocker) Unlock() { k.lock.Unlock(k.key) }
+
+type keyRLocker keyLocker
+
+func (k keyRLocker) Lock()   { k.lock.RLock(k.key) }
+func (k keyRLocker) Unlock() { k.lock.RUnlock(k.key) }
+
+// Holders returns how many locks taken with Lock or RLock are held now.
+func (l *KeyRWLock) Holders() int {
+	return int(l.holders.Load())
 }
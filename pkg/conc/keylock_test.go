@@ -107,3 +107,37 @@ func TestKeyRWLock(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyRWLockHolders(t *testing.T) {
+	keylock := NewKeyRWLock()
+	keylock.Lock("hello")
+	keylock.RLock("world")
+	keylock.RLock("world")
+	if h := keylock.Holders(); h != 3 {
+		t.Errorf("Holders is %d, but should be 3", h)
+	}
+
+	keylock.Unlock("hello")
+	keylock.RUnlock("world")
+	keylock.RUnlock("world")
+	if h := keylock.Holders(); h != 0 {
+		t.Errorf("Holders is %d, but should be 0", h)
+	}
+}
+
+func TestKeyRWLockLockersHolders(t *testing.T) {
+	keylock := NewKeyRWLock()
+	writer := keylock.KeyLocker("hello")
+	reader := keylock.KeyRLocker("world")
+	writer.Lock()
+	reader.Lock()
+	if h := keylock.Holders(); h != 2 {
+		t.Errorf("Holders is %d, but should be 2", h)
+	}
+
+	writer.Unlock()
+	reader.Unlock()
+	if h := keylock.Holders(); h != 0 {
+		t.Errorf("Holders is %d, but should be 0", h)
+	}
+}
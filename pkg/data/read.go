@@ -89,3 +89,71 @@ func (r *ProgressReader) percent() int {
 func bytes(size int) string {
 	return strings.ReplaceAll(humanize.IBytes(uint64(size)), " ", "")
 }
+
+type CancelReadCloser struct {
+	reader io.Reader
+	closer io.Closer
+	cancel func()
+}
+
+func NewCancelReadCloser(reader io.Reader, closer io.Closer, cancel func()) io.ReadCloser {
+	return &CancelReadCloser{
+		reader: reader,
+		closer: closer,
+		cancel: cancel,
+	}
+}
+
+func (r *CancelReadCloser) Read(p []byte) (n int, err error) {
+	return r.reader.Read(p)
+}
+
+func (r *CancelReadCloser) Close() error {
+	defer r.cancel()
+	return r.closer.Close()
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func Zeros(size int) io.Reader {
+	return io.LimitReader(zeros{}, int64(size))
+}
+
+type ThrottleReader struct {
+	reader  io.Reader
+	rate    int
+	started time.Time
+	count   int
+}
+
+// NewThrottleReader limits reading to rate bytes per second, zero rate
+// means no limit.
+func NewThrottleReader(reader io.Reader, rate int) io.Reader {
+	if rate <= 0 {
+		return reader
+	}
+	return &ThrottleReader{
+		reader:  reader,
+		rate:    rate,
+		started: time.Now(),
+	}
+}
+
+func (r *ThrottleReader) Read(p []byte) (n int, err error) {
+	if len(p) > r.rate {
+		p = p[:r.rate]
+	}
+	n, err = r.reader.Read(p)
+	r.count += n
+
+	expected := time.Duration(float64(r.count) / float64(r.rate) * float64(time.Second))
+	if wait := expected - time.Since(r.started); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
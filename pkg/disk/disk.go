@@ -0,0 +1,72 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type Usage struct {
+	Free  int
+	Total int
+}
+
+type Size struct {
+	Files int
+	Bytes int
+}
+
+// Measure sums the regular files under the dir, a missing dir is empty.
+func Measure(dir string) (Size, error) {
+	size := Size{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size.Files++
+		size.Bytes += int(info.Size())
+		return nil
+	})
+	if err != nil {
+		return Size{}, fmt.Errorf("walk dir: %w", err)
+	}
+
+	return size, nil
+}
+
+// Writable creates and removes a file in the dir.
+func Writable(dir string) (e error) {
+	file, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer func() {
+		e = errors.Join(e, os.Remove(file.Name()))
+	}()
+
+	if _, err := file.Write([]byte{0}); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("write file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
+	}
+
+	return nil
+}
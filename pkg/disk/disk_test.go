@@ -0,0 +1,47 @@
+package disk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritable(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, Writable(dir))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.Error(t, Writable(filepath.Join(dir, "missing")))
+}
+
+func TestStat(t *testing.T) {
+	usage, err := Stat(t.TempDir())
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	assert.Positive(t, usage.Total)
+	assert.LessOrEqual(t, usage.Free, usage.Total)
+}
+
+func TestMeasure(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("hello"), 0o600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("hi"), 0o600))
+
+	size, err := Measure(dir)
+	require.NoError(t, err)
+	assert.Equal(t, Size{Files: 2, Bytes: 7}, size)
+
+	size, err = Measure(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Equal(t, Size{}, size)
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package disk
+
+import (
+	"errors"
+	"fmt"
+)
+
+func Stat(path string) (Usage, error) {
+	return Usage{}, fmt.Errorf("statfs %s: %w", path, errors.ErrUnsupported)
+}
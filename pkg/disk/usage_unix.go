@@ -0,0 +1,22 @@
+//go:build linux || darwin
+
+package disk
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Stat returns the space available to unprivileged users and the total
+// space of the file system holding the path.
+func Stat(path string) (Usage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return Usage{}, fmt.Errorf("statfs: %w", err)
+	}
+
+	return Usage{
+		Free:  int(uint64(stat.Bavail) * uint64(stat.Bsize)),
+		Total: int(uint64(stat.Blocks) * uint64(stat.Bsize)),
+	}, nil
+}
@@ -0,0 +1,156 @@
+// Package erasure implements systematic Reed-Solomon coding over GF(2^8).
+// Parity rows come from a Cauchy matrix, so any data shards count of
+// the total shards is enough to restore the rest.
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrShards   = errors.New("invalid shards count")
+	ErrSize     = errors.New("shards have different sizes")
+	ErrTooFew   = errors.New("too few shards to reconstruct")
+	ErrSingular = errors.New("matrix is singular")
+)
+
+type Coder struct {
+	data   int
+	parity int
+	// matrix has a row per shard, identity rows for data ones
+	matrix [][]byte
+}
+
+func New(data, parity int) (*Coder, error) {
+	if data < 1 || parity < 0 || data+parity > 256 {
+		return nil, fmt.Errorf("%w: %d data, %d parity", ErrShards, data, parity)
+	}
+
+	matrix := make([][]byte, data+parity)
+	for i := range matrix {
+		matrix[i] = make([]byte, data)
+		if i < data {
+			matrix[i][i] = 1
+			continue
+		}
+		for j := 0; j < data; j++ {
+			// x = i and y = j never collide since i >= data > j
+			matrix[i][j] = inv(byte(i) ^ byte(j))
+		}
+	}
+
+	return &Coder{
+		data:   data,
+		parity: parity,
+		matrix: matrix,
+	}, nil
+}
+
+func (c *Coder) Data() int {
+	return c.data
+}
+
+func (c *Coder) Parity() int {
+	return c.parity
+}
+
+// Encode fills parity shards from data ones, all shards must be allocated
+// and have the same size.
+func (c *Coder) Encode(shards [][]byte) error {
+	size, err := c.check(shards, false)
+	if err != nil {
+		return err
+	}
+
+	for i := c.data; i < len(shards); i++ {
+		c.combine(shards[i][:size], c.matrix[i], shards[:c.data])
+	}
+	return nil
+}
+
+// Reconstruct restores every nil shard.
+func (c *Coder) Reconstruct(shards [][]byte) error {
+	return c.reconstruct(shards, true)
+}
+
+// ReconstructData restores nil data shards and leaves nil parity ones as is.
+func (c *Coder) ReconstructData(shards [][]byte) error {
+	return c.reconstruct(shards, false)
+}
+
+func (c *Coder) reconstruct(shards [][]byte, parity bool) error {
+	size, err := c.check(shards, true)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]byte, 0, c.data)
+	present := make([][]byte, 0, c.data)
+	for i := 0; i < len(shards) && len(rows) < c.data; i++ {
+		if shards[i] != nil {
+			rows = append(rows, c.matrix[i])
+			present = append(present, shards[i])
+		}
+	}
+	if len(rows) < c.data {
+		return ErrTooFew
+	}
+
+	decode, err := invert(rows)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < c.data; i++ {
+		if shards[i] != nil {
+			continue
+		}
+		shards[i] = make([]byte, size)
+		c.combine(shards[i], decode[i], present)
+	}
+
+	if !parity {
+		return nil
+	}
+	for i := c.data; i < len(shards); i++ {
+		if shards[i] != nil {
+			continue
+		}
+		shards[i] = make([]byte, size)
+		c.combine(shards[i], c.matrix[i], shards[:c.data])
+	}
+	return nil
+}
+
+func (c *Coder) combine(dst []byte, row []byte, sources [][]byte) {
+	clear(dst)
+	for j, source := range sources {
+		mulAdd(dst, source[:len(dst)], row[j])
+	}
+}
+
+func (c *Coder) check(shards [][]byte, missing bool) (size int, e error) {
+	if len(shards) != c.data+c.parity {
+		return 0, fmt.Errorf("%w: got %d, want %d", ErrShards, len(shards), c.data+c.parity)
+	}
+
+	size = -1
+	for _, shard := range shards {
+		if shard == nil {
+			if !missing {
+				return 0, ErrTooFew
+			}
+			continue
+		}
+		if size >= 0 && len(shard) != size {
+			return 0, ErrSize
+		}
+		size = len(shard)
+	}
+	if size < 0 {
+		return 0, ErrTooFew
+	}
+
+	return size, nil
+}
@@ -0,0 +1,71 @@
+package erasure
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconstructAnyLoss(t *testing.T) {
+	data, parity, size := 4, 2, 1000
+	coder, err := New(data, parity)
+	require.NoError(t, err)
+
+	random := rand.New(rand.NewSource(1))
+	shards := make([][]byte, data+parity)
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < data {
+			random.Read(shards[i])
+		}
+	}
+	require.NoError(t, coder.Encode(shards))
+
+	for first := 0; first < len(shards); first++ {
+		for second := first + 1; second < len(shards); second++ {
+			damaged := make([][]byte, len(shards))
+			for i := range shards {
+				damaged[i] = bytes.Clone(shards[i])
+			}
+			damaged[first], damaged[second] = nil, nil
+
+			require.NoError(t, coder.Reconstruct(damaged))
+			assert.Equal(t, shards, damaged, "lost %d and %d", first, second)
+		}
+	}
+}
+
+func TestReconstructDataOnly(t *testing.T) {
+	coder, err := New(3, 2)
+	require.NoError(t, err)
+
+	shards := [][]byte{
+		[]byte("abcd"), []byte("efgh"), []byte("ijkl"),
+		make([]byte, 4), make([]byte, 4),
+	}
+	require.NoError(t, coder.Encode(shards))
+
+	damaged := [][]byte{nil, shards[1], shards[2], shards[3], nil}
+	require.NoError(t, coder.ReconstructData(damaged))
+	assert.Equal(t, []byte("abcd"), damaged[0])
+	assert.Nil(t, damaged[4])
+}
+
+func TestReconstructTooFew(t *testing.T) {
+	coder, err := New(3, 1)
+	require.NoError(t, err)
+
+	shards := [][]byte{nil, nil, []byte("ab"), []byte("cd")}
+	assert.ErrorIs(t, coder.Reconstruct(shards), ErrTooFew)
+}
+
+func TestInvalidShards(t *testing.T) {
+	_, err := New(0, 2)
+	assert.ErrorIs(t, err, ErrShards)
+
+	_, err = New(200, 57)
+	assert.ErrorIs(t, err, ErrShards)
+}
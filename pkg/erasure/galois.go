@@ -0,0 +1,98 @@
+package erasure
+
+// Arithmetic over GF(2^8) with the 0x11d reducing polynomial.
+const polynomial = 0x11d
+
+var (
+	expTable [512]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= polynomial
+		}
+	}
+	for i := 255; i < len(expTable); i++ {
+		expTable[i] = expTable[i-255]
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func inv(a byte) byte {
+	if a == 0 {
+		panic("erasure: inverse of zero")
+	}
+	return expTable[255-int(logTable[a])]
+}
+
+// mulAdd computes dst ^= c * src for every byte.
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	if c == 1 {
+		for i, v := range src {
+			dst[i] ^= v
+		}
+		return
+	}
+	lc := int(logTable[c])
+	for i, v := range src {
+		if v != 0 {
+			dst[i] ^= expTable[lc+int(logTable[v])]
+		}
+	}
+}
+
+// invert returns the inverse of a square matrix by Gauss-Jordan elimination.
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	work := make([][]byte, n)
+	for i := range work {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for row := col; row < n; row++ {
+			if work[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, ErrSingular
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := inv(work[col][col])
+		for i := range work[col] {
+			work[col][i] = mul(work[col][i], scale)
+		}
+		for row := 0; row < n; row++ {
+			if row != col && work[row][col] != 0 {
+				mulAdd(work[row], work[col], work[row][col])
+			}
+		}
+	}
+
+	out := make([][]byte, n)
+	for i := range out {
+		out[i] = work[i][n:]
+	}
+	return out, nil
+}
@@ -0,0 +1,66 @@
+package header
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Checker hashes the part payload written to it and compares it with the
+// length and the sum from the header.
+type Checker struct {
+	header Header
+	hash   hash.Hash
+	size   int
+}
+
+func NewChecker(h Header) *Checker {
+	return &Checker{
+		header: h,
+		hash:   sha256.New(),
+	}
+}
+
+func (c *Checker) Write(p []byte) (int, error) {
+	c.size += len(p)
+	if c.size > c.header.Length {
+		return 0, fmt.Errorf("%w: payload exceeds %d bytes", ErrCorrupt, c.header.Length)
+	}
+	return c.hash.Write(p)
+}
+
+func (c *Checker) Verify() error {
+	if c.size != c.header.Length {
+		return fmt.Errorf("%w: payload is %d bytes, header %d", ErrCorrupt, c.size, c.header.Length)
+	}
+	if sum := hex.EncodeToString(c.hash.Sum(nil)); sum != c.header.Sum {
+		return fmt.Errorf("%w: payload sum %s, header %s", ErrCorrupt, sum, c.header.Sum)
+	}
+	return nil
+}
+
+// Reader feeds the checker with everything read from r and returns the
+// verification error instead of io.EOF.
+func (c *Checker) Reader(r io.Reader) io.Reader {
+	return &checkReader{reader: r, checker: c}
+}
+
+type checkReader struct {
+	reader  io.Reader
+	checker *Checker
+}
+
+func (r *checkReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if _, werr := r.checker.Write(p[:n]); werr != nil {
+		return n, werr
+	}
+	if err == io.EOF {
+		if verr := r.checker.Verify(); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,199 @@
+package header
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+const (
+	// Legacy headers are a single byte with the parts count in the first
+	// part, which is never zero, so versioned headers start with a zero.
+	Legacy = 0
+	// Layout headers describe the file layout in the first part only.
+	Layout = 1
+	// Parts headers are checksummed and prepended to every part.
+	Parts = 2
+
+	Version = Parts
+
+	layoutSize = 1 + 1 + 4 + 8 + 8
+	partsSize  = len(magic) + 1 + sha + 4 + 4 + 8 + 8 + 8 + sha + 4
+	sha        = 32
+	magic      = "\x00BLP"
+)
+
+var (
+	ErrVersion = errors.New("unsupported header version")
+	ErrCount   = errors.New("parts count out of range")
+	ErrCorrupt = errors.New("corrupt part")
+)
+
+// Header is stored before the part payload. It describes the file layout,
+// so the file can be read back without a manifest, and since the parts
+// version it lets storages verify parts on their own.
+type Header struct {
+	Version int
+	Count   int
+	Chunk   int
+	Size    int
+	Digest  string
+	Index   int
+	Length  int
+	Sum     string
+}
+
+// Len returns the encoded header length.
+func (h Header) Len() int {
+	switch h.Version {
+	case Legacy:
+		return 1
+	case Layout:
+		return layoutSize
+	default:
+		return partsSize
+	}
+}
+
+// Has reports whether the prefix starts with a parts header.
+func Has(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(magic))
+}
+
+func (h Header) MarshalBinary() ([]byte, error) {
+	switch h.Version {
+	case Legacy:
+		if h.Count < 1 || h.Count > math.MaxUint8 {
+			return nil, fmt.Errorf("%w: %d", ErrCount, h.Count)
+		}
+		return []byte{byte(h.Count)}, nil
+	case Layout:
+		if h.Count < 1 || h.Count > math.MaxUint32 {
+			return nil, fmt.Errorf("%w: %d", ErrCount, h.Count)
+		}
+		b := make([]byte, 2, layoutSize)
+		b[0], b[1] = 0x00, byte(h.Version)
+		b = binary.BigEndian.AppendUint32(b, uint32(h.Count))
+		b = binary.BigEndian.AppendUint64(b, uint64(h.Chunk))
+		b = binary.BigEndian.AppendUint64(b, uint64(h.Size))
+		return b, nil
+	case Parts:
+		if h.Count < 1 || h.Count > math.MaxUint32 || h.Index < 0 || h.Index > math.MaxUint32 {
+			return nil, fmt.Errorf("%w: part %d of %d", ErrCount, h.Index, h.Count)
+		}
+		digest, err := decodeSum(h.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("file digest: %w", err)
+		}
+		sum, err := decodeSum(h.Sum)
+		if err != nil {
+			return nil, fmt.Errorf("part sum: %w", err)
+		}
+		b := make([]byte, 0, partsSize)
+		b = append(b, magic...)
+		b = append(b, byte(h.Version))
+		b = append(b, digest...)
+		b = binary.BigEndian.AppendUint32(b, uint32(h.Index))
+		b = binary.BigEndian.AppendUint32(b, uint32(h.Count))
+		b = binary.BigEndian.AppendUint64(b, uint64(h.Chunk))
+		b = binary.BigEndian.AppendUint64(b, uint64(h.Size))
+		b = binary.BigEndian.AppendUint64(b, uint64(h.Length))
+		b = append(b, sum...)
+		b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+		return b, nil
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrVersion, h.Version)
+	}
+}
+
+// Read decodes the header from the start of the part, leaving the reader
+// at the payload.
+func Read(r io.Reader) (Header, error) {
+	b := make([]byte, partsSize)
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return Header{}, fmt.Errorf("read header: %w", err)
+	}
+	if b[0] != 0x00 {
+		return Header{Version: Legacy, Count: int(b[0])}, nil
+	}
+
+	if _, err := io.ReadFull(r, b[1:2]); err != nil {
+		return Header{}, fmt.Errorf("read header: %w", err)
+	}
+	switch {
+	case b[1] == Layout:
+		return readLayout(r, b[:layoutSize])
+	case b[1] == magic[1]:
+		return readParts(r, b[:partsSize])
+	default:
+		return Header{}, fmt.Errorf("%w: %d", ErrVersion, b[1])
+	}
+}
+
+func readLayout(r io.Reader, b []byte) (Header, error) {
+	if _, err := io.ReadFull(r, b[2:]); err != nil {
+		return Header{}, fmt.Errorf("read header: %w", err)
+	}
+
+	h := Header{
+		Version: Layout,
+		Count:   int(binary.BigEndian.Uint32(b[2:6])),
+		Chunk:   int(binary.BigEndian.Uint64(b[6:14])),
+		Size:    int(binary.BigEndian.Uint64(b[14:22])),
+	}
+	if h.Count < 1 {
+		return Header{}, fmt.Errorf("%w: %d", ErrCount, h.Count)
+	}
+
+	return h, nil
+}
+
+func readParts(r io.Reader, b []byte) (Header, error) {
+	if _, err := io.ReadFull(r, b[2:]); err != nil {
+		return Header{}, fmt.Errorf("read header: %w", err)
+	}
+	if !Has(b) {
+		return Header{}, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+	if b[len(magic)] != Parts {
+		return Header{}, fmt.Errorf("%w: %d", ErrVersion, b[len(magic)])
+	}
+	body, crc := b[:len(b)-4], binary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.ChecksumIEEE(body) != crc {
+		return Header{}, fmt.Errorf("%w: header checksum mismatch", ErrCorrupt)
+	}
+
+	at := len(magic) + 1
+	h := Header{Version: Parts}
+	h.Digest = hex.EncodeToString(body[at : at+sha])
+	at += sha
+	h.Index = int(binary.BigEndian.Uint32(body[at : at+4]))
+	h.Count = int(binary.BigEndian.Uint32(body[at+4 : at+8]))
+	h.Chunk = int(binary.BigEndian.Uint64(body[at+8 : at+16]))
+	h.Size = int(binary.BigEndian.Uint64(body[at+16 : at+24]))
+	h.Length = int(binary.BigEndian.Uint64(body[at+24 : at+32]))
+	at += 32
+	h.Sum = hex.EncodeToString(body[at : at+sha])
+	// Parity parts of erasure coded files come after the counted data parts
+	if h.Count < 1 {
+		return Header{}, fmt.Errorf("%w: part %d of %d", ErrCount, h.Index, h.Count)
+	}
+
+	return h, nil
+}
+
+func decodeSum(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decode hex: %w", err)
+	}
+	if len(b) != sha {
+		return nil, fmt.Errorf("want %d bytes, got %d", sha, len(b))
+	}
+	return b, nil
+}
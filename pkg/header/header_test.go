@@ -0,0 +1,92 @@
+package header
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sum(b []byte) string {
+	s := sha256.Sum256(b)
+	return hex.EncodeToString(s[:])
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, h := range []Header{
+		{Version: Legacy, Count: 7},
+		{Version: Layout, Count: 70000, Chunk: 64 << 20, Size: 5 << 40},
+		{Version: Parts, Count: 3, Chunk: 8, Size: 21, Index: 2, Length: 7, Digest: sum([]byte("file")), Sum: sum([]byte("payload"))},
+		{Version: Parts, Count: 3, Chunk: 8, Size: 21, Index: 4, Length: 7, Digest: sum([]byte("file")), Sum: sum([]byte("payload"))},
+	} {
+		b, err := h.MarshalBinary()
+		require.NoError(t, err)
+		assert.Len(t, b, h.Len())
+		assert.Equal(t, h.Version == Parts, Has(b))
+
+		reader := io.MultiReader(bytes.NewReader(b), bytes.NewReader([]byte("payload")))
+		got, err := Read(reader)
+		require.NoError(t, err)
+		assert.Equal(t, h, got)
+
+		rest, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(rest))
+	}
+}
+
+func TestParityIndex(t *testing.T) {
+	// A 4+2 erasure coded file counts its data parts only
+	for index := range 6 {
+		h := Header{Version: Parts, Count: 4, Index: index, Length: 7, Digest: sum([]byte("file")), Sum: sum([]byte("payload"))}
+		b, err := h.MarshalBinary()
+		require.NoError(t, err)
+
+		got, err := Read(bytes.NewReader(b))
+		require.NoError(t, err, "part %d", index)
+		assert.Equal(t, h, got)
+	}
+
+	_, err := Header{Version: Parts, Count: 0, Index: 4, Digest: sum(nil), Sum: sum(nil)}.MarshalBinary()
+	assert.ErrorIs(t, err, ErrCount)
+}
+
+func TestInvalid(t *testing.T) {
+	_, err := Header{Version: Legacy, Count: 256}.MarshalBinary()
+	assert.ErrorIs(t, err, ErrCount)
+
+	_, err = Header{Version: 9, Count: 1}.MarshalBinary()
+	assert.ErrorIs(t, err, ErrVersion)
+
+	_, err = Read(bytes.NewReader([]byte{0x00, 9}))
+	assert.ErrorIs(t, err, ErrVersion)
+
+	_, err = Read(bytes.NewReader([]byte{0x00, Layout, 0}))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	b, err := Header{Version: Parts, Count: 1, Digest: sum(nil), Sum: sum(nil)}.MarshalBinary()
+	require.NoError(t, err)
+	b[10] ^= 0xff
+	_, err = Read(bytes.NewReader(b))
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestChecker(t *testing.T) {
+	h := Header{Version: Parts, Count: 1, Length: 7, Digest: sum(nil), Sum: sum([]byte("payload"))}
+
+	_, err := io.ReadAll(NewChecker(h).Reader(bytes.NewReader([]byte("payload"))))
+	assert.NoError(t, err)
+
+	_, err = io.ReadAll(NewChecker(h).Reader(bytes.NewReader([]byte("paylo"))))
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	_, err = io.ReadAll(NewChecker(h).Reader(bytes.NewReader([]byte("payloaded"))))
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	_, err = io.ReadAll(NewChecker(h).Reader(bytes.NewReader([]byte("Payload"))))
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
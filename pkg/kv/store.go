@@ -0,0 +1,244 @@
+package kv
+
+import (
+	"balancer/pkg/data"
+	"balancer/pkg/errs"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// minCompact is how many changes the log holds at least before it is
+// folded into the snapshot.
+const minCompact = 1024
+
+// Store keeps the items in memory. The file at path holds a snapshot of
+// them and every change is appended to a log next to it, so a write costs
+// the same whatever the number of items. The log is folded into the
+// snapshot once it has more changes than there are items.
+type Store[T any] struct {
+	path    string
+	mu      sync.RWMutex
+	items   map[string]T
+	changes int
+}
+
+// change is a log entry, a deleted key has no value.
+type change[T any] struct {
+	Key     string `json:"key"`
+	Value   T      `json:"value,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+func Open[T any](path string) (*Store[T], error) {
+	s := &Store[T]{
+		path:  path,
+		items: map[string]T{},
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read store: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(raw, &s.items); err != nil {
+			return nil, fmt.Errorf("decode store: %w", err)
+		}
+	}
+
+	if err := s.replay(); err != nil {
+		return nil, fmt.Errorf("replay log: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *Store[T]) Get(key string) (T, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.items[key]
+	return v, ok
+}
+
+func (s *Store[T]) Put(key string, value T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(change[T]{Key: key, Value: value}); err != nil {
+		return err
+	}
+	s.items[key] = value
+	s.compact()
+
+	return nil
+}
+
+func (s *Store[T]) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, existed := s.items[key]; !existed {
+		return nil
+	}
+	if err := s.append(change[T]{Key: key, Deleted: true}); err != nil {
+		return err
+	}
+	delete(s.items, key)
+	s.compact()
+
+	return nil
+}
+
+func (s *Store[T]) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.items))
+	for k := range s.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Store[T]) Range(f func(key string, value T) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for k, v := range s.items {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+func (s *Store[T]) log() string {
+	return s.path + ".log"
+}
+
+// replay applies the logged changes to the snapshot. A change torn by a
+// crash while it was appended is cut off, it was never acknowledged.
+func (s *Store[T]) replay() (e error) {
+	f, err := os.OpenFile(s.log(), os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer errs.Close(&e, f.Close)
+
+	decoder := json.NewDecoder(f)
+	for {
+		var c change[T]
+		err := decoder.Decode(&c)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			if err := f.Truncate(decoder.InputOffset()); err != nil {
+				return fmt.Errorf("cut torn change: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("decode change: %w", err)
+		}
+
+		if c.Deleted {
+			delete(s.items, c.Key)
+		} else {
+			s.items[c.Key] = c.Value
+		}
+		s.changes++
+	}
+}
+
+func (s *Store[T]) append(c change[T]) (e error) {
+	if err := data.EnsureDir(filepath.Dir(s.path)); err != nil {
+		return fmt.Errorf("create dir: %w", err)
+	}
+
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encode change: %w", err)
+	}
+
+	f, err := os.OpenFile(s.log(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer errs.Close(&e, f.Close)
+
+	if _, err := f.Write(append(raw, '\n')); err != nil {
+		return fmt.Errorf("write log: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync log: %w", err)
+	}
+	s.changes++
+
+	return nil
+}
+
+// compact folds the log into the snapshot once replaying it would cost
+// more than reading the snapshot, which keeps writes amortized constant.
+// The change is logged already, so a failure is only retried later.
+func (s *Store[T]) compact() {
+	if s.changes < max(minCompact, len(s.items)) {
+		return
+	}
+
+	if err := s.save(); err != nil {
+		slog.Error("compact store", "path", s.path, "error", err)
+		return
+	}
+	if err := os.Remove(s.log()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("compact store", "path", s.path, "error", err)
+		return
+	}
+	s.changes = 0
+}
+
+func (s *Store[T]) save() (e error) {
+	dir := filepath.Dir(s.path)
+	if err := data.EnsureDir(dir); err != nil {
+		return fmt.Errorf("create dir: %w", err)
+	}
+
+	raw, err := json.Marshal(s.items)
+	if err != nil {
+		return fmt.Errorf("encode store: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp: %w", err)
+	}
+	defer errs.Close(&e, data.SilentRemoveCloser(f.Name()))
+
+	if _, err := f.Write(raw); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write temp: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("sync temp: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close temp: %w", err)
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return fmt.Errorf("rename temp: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "index.json")
+
+	first, err := Open[string](path)
+	require.NoError(t, err)
+	require.NoError(t, first.Put("name-a:part-0", "hash-a"))
+	require.NoError(t, first.Put("name-b:part-0", "hash-b"))
+	require.NoError(t, first.Put("name-c:part-0", "hash-c"))
+	require.NoError(t, first.Delete("name-b:part-0"))
+
+	second, err := Open[string](path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name-a:part-0", "name-c:part-0"}, second.Keys())
+
+	value, ok := second.Get("name-c:part-0")
+	assert.True(t, ok)
+	assert.Equal(t, "hash-c", value)
+
+	_, ok = second.Get("name-b:part-0")
+	assert.False(t, ok)
+}
+
+func TestStoreMissingFile(t *testing.T) {
+	s, err := Open[int](filepath.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+	assert.Empty(t, s.Keys())
+	assert.NoError(t, s.Delete("nothing"))
+}
+
+func TestStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+
+	first, err := Open[int](path)
+	require.NoError(t, err)
+	want := map[string]int{}
+	for i := range minCompact + 10 {
+		key := fmt.Sprintf("key-%d", i%20)
+		require.NoError(t, first.Put(key, i))
+		want[key] = i
+	}
+	require.NoError(t, first.Delete("key-3"))
+	delete(want, "key-3")
+
+	// The snapshot was rewritten once, the log only holds what came after
+	assert.FileExists(t, path)
+	assert.Equal(t, 11, first.changes)
+
+	second, err := Open[int](path)
+	require.NoError(t, err)
+	assert.Len(t, second.Keys(), len(want))
+	for key, value := range want {
+		got, ok := second.Get(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, value, got, key)
+	}
+}
+
+func TestStoreTornLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+
+	first, err := Open[string](path)
+	require.NoError(t, err)
+	require.NoError(t, first.Put("a", "hash-a"))
+	require.NoError(t, first.Put("b", "hash-b"))
+
+	f, err := os.OpenFile(path+".log", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"key":"c","val`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	second, err := Open[string](path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, second.Keys())
+
+	require.NoError(t, second.Put("c", "hash-c"))
+	third, err := Open[string](path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, third.Keys())
+}
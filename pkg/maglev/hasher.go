@@ -166,6 +166,11 @@ func (p *Hasher) populate() {
 		p.eMu.Unlock()
 	}()
 
+	p.fill()
+}
+
+// Fill lookup table, all resources must be locked by the caller.
+func (p *Hasher) fill() {
 	// n and m are protected by bMu and pMu.
 	n := int(p.n)
 	m := int(p.m)
@@ -175,6 +180,9 @@ func (p *Hasher) populate() {
 	for i := 0; i < m; i++ {
 		p.entry[i] = -1
 	}
+	if n == 0 {
+		return
+	}
 
 	j := 0
 	for {
@@ -212,7 +220,7 @@ func (p *Hasher) AddBackends(backends []string) {
 	p.populate()
 }
 
-// Add a list of backends to Maglev hashing.
+// Remove a list of backends from Maglev hashing.
 // It will remove corresponding data from permutation array
 // and re-populate lookup table.
 func (p *Hasher) RemoveBackends(backends []string) {
@@ -249,6 +257,8 @@ func (p *Hasher) RemoveBackends(backends []string) {
 		}
 		start = delList[i] + 1
 	}
+	bbuf = append(bbuf, p.backends[start:]...)
+	pbuf = append(pbuf, p.permutation[start:]...)
 	p.backends = bbuf
 	p.permutation = pbuf
 
@@ -259,7 +269,9 @@ func (p *Hasher) RemoveBackends(backends []string) {
 		p.bIndex[string(b)] = i
 	}
 
-	go p.populate()
+	// Lookup table is refilled under the same locks, so no flow
+	// can be mapped to a removed backend index in between.
+	p.fill()
 }
 
 // Get the backend number
@@ -267,6 +279,18 @@ func (p *Hasher) BackendsNum() (count int) {
 	return int(atomic.LoadInt32(&p.n))
 }
 
+// Get the backend names in insertion order
+func (p *Hasher) Backends() (backends []string) {
+	p.bMu.RLock()
+	defer p.bMu.RUnlock()
+
+	backends = make([]string, len(p.backends))
+	for i, b := range p.backends {
+		backends[i] = string(b)
+	}
+	return
+}
+
 // Get the M value
 func (p *Hasher) M() (m int) {
 	return int(atomic.LoadInt32((&p.m)))
@@ -292,9 +316,50 @@ func (p *Hasher) GetBackend(flow string) (backend string) {
 	}
 
 	bIdx := p.entry[int(fhash%uint64(m))]
+	if bIdx < 0 {
+		return ""
+	}
 	return string(p.backends[bIdx])
 }
 
+// Get up to n distinct backends for provided flow. The first one is
+// the same as GetBackend returns, the rest are found by walking the
+// lookup table forward from the flow entry.
+func (p *Hasher) GetBackends(flow string, n int) (backends []string) {
+	fnv := fnv.New64()
+	fnv.Write([]byte(flow))
+	fhash := fnv.Sum64()
+
+	p.eMu.RLock()
+	p.bMu.RLock()
+	defer func() {
+		p.eMu.RUnlock()
+		p.bMu.RUnlock()
+	}()
+
+	m := int(atomic.LoadInt32((&p.m)))
+
+	if len(p.entry) != m {
+		panic("internal index inconsistent")
+	}
+
+	n = min(n, len(p.backends))
+	if n == 0 {
+		return
+	}
+	seen := make(map[int]bool, n)
+	start := int(fhash % uint64(m))
+	for i := 0; i < m && len(backends) < n; i++ {
+		bIdx := p.entry[(start+i)%m]
+		if seen[bIdx] {
+			continue
+		}
+		seen[bIdx] = true
+		backends = append(backends, string(p.backends[bIdx]))
+	}
+	return
+}
+
 // Return the current lookup table. (for debug use)
 func (p *Hasher) LookupTable() (lookup []string) {
 	p.eMu.RLock()
@@ -307,7 +372,9 @@ func (p *Hasher) LookupTable() (lookup []string) {
 	m := len(p.entry)
 	lookup = make([]string, m)
 	for i, bIdx := range p.entry {
-		lookup[i] = string(p.backends[bIdx])
+		if bIdx >= 0 {
+			lookup[i] = string(p.backends[bIdx])
+		}
 	}
 	return
 }
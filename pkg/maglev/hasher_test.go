@@ -1,6 +1,7 @@
 package maglev
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,3 +37,42 @@ func TestEventualConsistency(t *testing.T) {
 		}
 	}
 }
+
+func TestDistinctBackends(t *testing.T) {
+	hash := NewHasher(DefaultPrime)
+	hash.AddBackends([]string{"backend-1", "backend-2", "backend-3", "backend-4"})
+
+	for i := 0; i < 100; i++ {
+		flow := fmt.Sprintf("file-%d:part-0", i)
+		backends := hash.GetBackends(flow, 3)
+
+		assert.Len(t, backends, 3)
+		assert.Equal(t, hash.GetBackend(flow), backends[0])
+		assert.NotEqual(t, backends[0], backends[1])
+		assert.NotEqual(t, backends[1], backends[2])
+		assert.NotEqual(t, backends[0], backends[2])
+	}
+
+	assert.Len(t, hash.GetBackends("file-0:part-0", 10), 4)
+}
+
+func TestRemoveBackends(t *testing.T) {
+	hash := NewHasher(DefaultPrime)
+	hash.AddBackends([]string{"backend-1", "backend-2", "backend-3", "backend-4"})
+	hash.RemoveBackends([]string{"backend-2"})
+
+	assert.Equal(t, []string{"backend-1", "backend-3", "backend-4"}, hash.Backends())
+
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		seen[hash.GetBackend(fmt.Sprintf("file-%d:part-0", i))] = true
+	}
+	assert.Equal(t, map[string]bool{"backend-1": true, "backend-3": true, "backend-4": true}, seen)
+
+	hash.RemoveBackends([]string{"backend-1", "backend-3", "backend-4"})
+	assert.Equal(t, "", hash.GetBackend("file-0:part-0"))
+	assert.Empty(t, hash.GetBackends("file-0:part-0", 2))
+
+	hash.AddBackends([]string{"backend-2"})
+	assert.Equal(t, "backend-2", hash.GetBackend("file-0:part-0"))
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTP records requests served by a handler into a registry.
+type HTTP struct {
+	requests *Counter
+	duration *Histogram
+	received *Counter
+	sent     *Counter
+}
+
+func NewHTTP(r *Registry) *HTTP {
+	return &HTTP{
+		requests: r.Counter("http_requests_total", "Requests by route, method and status code.", "route", "method", "code"),
+		duration: r.Histogram("http_request_duration_seconds", "Request durations by route.", DefaultBuckets, "route", "method"),
+		received: r.Counter("http_request_bytes_total", "Bytes read from request bodies by route.", "route"),
+		sent:     r.Counter("http_response_bytes_total", "Bytes written to response bodies by route.", "route"),
+	}
+}
+
+// Wrap labels requests with the pattern the mux matched, so paths with
+// names do not make a series each.
+func (m *HTTP) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		body := &countReader{r: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		writer := &countResponse{ResponseWriter: w, code: http.StatusOK}
+
+		h.ServeHTTP(writer, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requests.Inc(route, r.Method, strconv.Itoa(writer.code))
+		m.duration.Observe(time.Since(start).Seconds(), route, r.Method)
+		m.received.Add(float64(body.n), route)
+		m.sent.Add(float64(writer.n), route)
+	})
+}
+
+type countReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countReader) Close() error {
+	return c.r.Close()
+}
+
+type countResponse struct {
+	http.ResponseWriter
+	code    int
+	written bool
+	n       int64
+}
+
+func (c *countResponse) WriteHeader(code int) {
+	if !c.written {
+		c.code = code
+		c.written = true
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *countResponse) Write(p []byte) (int, error) {
+	c.written = true
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countResponse) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *countResponse) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
@@ -0,0 +1,320 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are latency buckets in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+var Default = NewRegistry()
+
+// Sample is a single value of a metric with label values in the order of
+// the metric labels.
+type Sample struct {
+	Values []string
+	Value  float64
+}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry writes its metrics in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	names   []string
+	metrics map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]collector)}
+}
+
+// register keeps the first metric with the name, so packages can declare
+// metrics as variables and constructors can be called more than once.
+func (r *Registry) register(name string, c collector) collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if was, ok := r.metrics[name]; ok {
+		return was
+	}
+	r.names = append(r.names, name)
+	r.metrics[name] = c
+	return c
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: newFamily(name, help, "counter", labels)}
+	return r.register(name, c).(*Counter)
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{family: newFamily(name, help, "gauge", labels)}
+	return r.register(name, g).(*Gauge)
+}
+
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		family:  newFamily(name, help, "histogram", labels),
+		buckets: slices.Sorted(slices.Values(buckets)),
+		series:  make(map[string]*histogramSeries),
+	}
+	return r.register(name, h).(*Histogram)
+}
+
+// GaugeFunc calls collect on every scrape. A later call with the same name
+// replaces the function.
+func (r *Registry) GaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	f := &gaugeFunc{family: newFamily(name, help, "gauge", labels), collect: collect}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.metrics[name] = f
+}
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := make([]collector, 0, len(r.names))
+	for _, name := range slices.Sorted(slices.Values(r.names)) {
+		collectors = append(collectors, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	counter := &countWriter{w: w}
+	buffered := bufio.NewWriter(counter)
+	for _, c := range collectors {
+		c.write(buffered)
+	}
+	err := buffered.Flush()
+	return counter.n, err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func newFamily(name, help, kind string, labels []string) family {
+	return family{name: name, help: help, kind: kind, labels: labels}
+}
+
+func (f family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.ReplaceAll(f.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+func (f family) line(w *bufio.Writer, suffix string, values []string, extra string, value float64) {
+	w.WriteString(f.name)
+	w.WriteString(suffix)
+	pairs := make([]string, 0, len(values)+1)
+	for i, label := range f.labels {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs = append(pairs, label+`="`+escape(v)+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	w.WriteString(" " + format(value) + "\n")
+}
+
+type series struct {
+	mu     sync.Mutex
+	keys   []string
+	values map[string][]string
+	data   map[string]float64
+}
+
+func (s *series) add(values []string, delta float64, set bool) {
+	key := strings.Join(values, "\xff")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data == nil {
+		s.values = make(map[string][]string)
+		s.data = make(map[string]float64)
+	}
+	if _, ok := s.data[key]; !ok {
+		s.keys = append(s.keys, key)
+		s.values[key] = slices.Clone(values)
+	}
+	if set {
+		s.data[key] = delta
+	} else {
+		s.data[key] += delta
+	}
+}
+
+func (s *series) samples() []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	samples := make([]Sample, 0, len(s.keys))
+	for _, key := range slices.Sorted(slices.Values(s.keys)) {
+		samples = append(samples, Sample{Values: s.values[key], Value: s.data[key]})
+	}
+	return samples
+}
+
+type Counter struct {
+	family
+	series series
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.series.add(values, 1, false)
+}
+
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		return
+	}
+	c.series.add(values, delta, false)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w)
+	for _, s := range c.series.samples() {
+		c.line(w, "", s.Values, "", s.Value)
+	}
+}
+
+type Gauge struct {
+	family
+	series series
+}
+
+func (g *Gauge) Set(value float64, values ...string) {
+	g.series.add(values, value, true)
+}
+
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.series.add(values, delta, false)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.header(w)
+	for _, s := range g.series.samples() {
+		g.line(w, "", s.Values, "", s.Value)
+	}
+}
+
+type gaugeFunc struct {
+	family
+	collect func() []Sample
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.header(w)
+	samples := g.collect()
+	slices.SortFunc(samples, func(a, b Sample) int {
+		return slices.Compare(a.Values, b.Values)
+	})
+	for _, s := range samples {
+		g.line(w, "", s.Values, "", s.Value)
+	}
+}
+
+type Histogram struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(value float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: slices.Clone(values), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, key := range slices.Sorted(maps.Keys(h.series)) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			h.line(w, "_bucket", s.values, `le="`+format(bound)+`"`, float64(s.counts[i]))
+		}
+		h.line(w, "_bucket", s.values, `le="+Inf"`, float64(s.count))
+		h.line(w, "_sum", s.values, "", s.sum)
+		h.line(w, "_count", s.values, "", float64(s.count))
+	}
+}
+
+func format(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func escape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	return b.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("parts_total", "Parts.", "backend")
+	c.Inc("a")
+	c.Add(2, "a")
+	c.Add(-1, "a")
+	c.Inc(`b"\`)
+
+	g := r.Gauge("temp_bytes", "Temp bytes.")
+	g.Set(10)
+	g.Add(-3)
+
+	assert.Same(t, c, r.Counter("parts_total", "Parts.", "backend"))
+
+	assert.Equal(t, `# HELP parts_total Parts.
+# TYPE parts_total counter
+parts_total{backend="a"} 3
+parts_total{backend="b\"\\"} 1
+# HELP temp_bytes Temp bytes.
+# TYPE temp_bytes gauge
+temp_bytes 7
+`, scrape(t, r))
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("latency_seconds", "Latency.", []float64{1, 0.1}, "op")
+	h.Observe(0.05, "save")
+	h.Observe(0.5, "save")
+	h.Observe(5, "save")
+
+	assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="save",le="0.1"} 1
+latency_seconds_bucket{op="save",le="1"} 2
+latency_seconds_bucket{op="save",le="+Inf"} 3
+latency_seconds_sum{op="save"} 5.55
+latency_seconds_count{op="save"} 3
+`, scrape(t, r))
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	r.GaugeFunc("jobs", "Jobs.", []string{"state"}, func() []Sample {
+		return nil
+	})
+	r.GaugeFunc("jobs", "Jobs.", []string{"state"}, func() []Sample {
+		return []Sample{{Values: []string{"failed"}, Value: 1}, {Values: []string{"done"}, Value: 2}}
+	})
+
+	assert.Equal(t, `# HELP jobs Jobs.
+# TYPE jobs gauge
+jobs{state="done"} 2
+jobs{state="failed"} 1
+`, scrape(t, r))
+}
+
+func TestHTTP(t *testing.T) {
+	r := NewRegistry()
+	m := http.NewServeMux()
+	m.HandleFunc("POST /files/{name}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("ok"))
+	})
+	h := NewHTTP(r).Wrap(m)
+
+	for _, name := range []string{"a", "b"} {
+		req := httptest.NewRequest(http.MethodPost, "/files/"+name, strings.NewReader("body"))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/other", nil))
+
+	out := scrape(t, r)
+	assert.Contains(t, out, `http_requests_total{route="POST /files/{name}",method="POST",code="201"} 2`)
+	assert.Contains(t, out, `http_requests_total{route="unmatched",method="GET",code="404"} 1`)
+	assert.Contains(t, out, `http_request_bytes_total{route="POST /files/{name}"} 8`)
+	assert.Contains(t, out, `http_response_bytes_total{route="POST /files/{name}"} 4`)
+	assert.Contains(t, out, `http_request_duration_seconds_count{route="POST /files/{name}",method="POST"} 2`)
+}
@@ -0,0 +1,90 @@
+package reload
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watcher calls reload when the file modification time changes or when
+// the process receives SIGHUP.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	reload   func() error
+	notify   chan os.Signal
+	stop     chan struct{}
+	done     chan struct{}
+	modified time.Time
+}
+
+func Watch(path string, interval time.Duration, reload func() error) *Watcher {
+	w := &Watcher{
+		path:     path,
+		interval: interval,
+		reload:   reload,
+		notify:   make(chan os.Signal, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		modified: modified(path),
+	}
+	signal.Notify(w.notify, syscall.SIGHUP)
+
+	go w.run()
+	return w
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.notify:
+			w.modified = modified(w.path)
+			w.apply("signal")
+		case <-ticker.C:
+			now := modified(w.path)
+			if now.Equal(w.modified) {
+				continue
+			}
+			w.modified = now
+			w.apply("change")
+		}
+	}
+}
+
+func (w *Watcher) apply(cause string) {
+	if err := w.reload(); err != nil {
+		slog.Error("reload", "path", w.path, "cause", cause, "error", err)
+		return
+	}
+	slog.Info("reloaded", "path", w.path, "cause", cause)
+}
+
+func (w *Watcher) Close(ctx context.Context) error {
+	signal.Stop(w.notify)
+	close(w.stop)
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func modified(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,77 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+type permanent struct {
+	err error
+}
+
+func (p permanent) Error() string { return p.err.Error() }
+func (p permanent) Unwrap() error { return p.err }
+
+// Permanent marks the error as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanent{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p permanent
+	return errors.As(err, &p)
+}
+
+// Backoff retries with exponentially growing delays and full jitter, so
+// clients failing together do not retry together.
+type Backoff struct {
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+}
+
+// Delay returns a random delay before the attempt following the given one.
+func (b Backoff) Delay(attempt int) time.Duration {
+	ceiling := b.Max
+	if shift := attempt - 1; shift < 32 && b.Base<<shift > 0 && b.Base<<shift < ceiling {
+		ceiling = b.Base << shift
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// Do calls fn until it succeeds, returns a permanent error, the attempts
+// run out or the context is done. The last error is returned.
+func (b Backoff) Do(ctx context.Context, fn func(attempt int) error) error {
+	var err error
+	for attempt := 1; attempt <= max(b.Attempts, 1); attempt++ {
+		if err = fn(attempt); err == nil {
+			return nil
+		}
+		var p permanent
+		if errors.As(err, &p) {
+			return p.err
+		}
+		if attempt == b.Attempts {
+			break
+		}
+
+		timer := time.NewTimer(b.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+
+	return fmt.Errorf("after %d attempts: %w", max(b.Attempts, 1), err)
+}
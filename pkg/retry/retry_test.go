@@ -0,0 +1,67 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errFlaky = errors.New("flaky")
+
+func TestDoRetries(t *testing.T) {
+	b := Backoff{Attempts: 3, Base: time.Millisecond, Max: 5 * time.Millisecond}
+
+	calls := 0
+	err := b.Do(context.Background(), func(attempt int) error {
+		calls++
+		if attempt < 3 {
+			return errFlaky
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = b.Do(context.Background(), func(int) error {
+		calls++
+		return errFlaky
+	})
+	assert.ErrorIs(t, err, errFlaky)
+	assert.Equal(t, 3, calls)
+}
+
+func TestDoPermanent(t *testing.T) {
+	b := Backoff{Attempts: 5, Base: time.Millisecond, Max: time.Millisecond}
+
+	calls := 0
+	err := b.Do(context.Background(), func(int) error {
+		calls++
+		return Permanent(errFlaky)
+	})
+	assert.ErrorIs(t, err, errFlaky)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDoCanceled(t *testing.T) {
+	b := Backoff{Attempts: 5, Base: time.Hour, Max: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := b.Do(ctx, func(int) error { return errFlaky })
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, errFlaky)
+}
+
+func TestDelay(t *testing.T) {
+	b := Backoff{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	for attempt := 1; attempt < 100; attempt++ {
+		delay := b.Delay(attempt)
+		assert.GreaterOrEqual(t, delay, time.Duration(0))
+		assert.LessOrEqual(t, delay, b.Max)
+	}
+	assert.LessOrEqual(t, b.Delay(1), b.Base)
+}
@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// JSON writes a line per span with OpenTelemetry field names, so spans from
+// every container can be merged and sorted by trace.
+type JSON struct {
+	service string
+	mu      sync.Mutex
+	w       io.Writer
+	closer  io.Closer
+}
+
+type line struct {
+	Service  string         `json:"service"`
+	Trace    string         `json:"traceId"`
+	Span     string         `json:"spanId"`
+	Parent   string         `json:"parentSpanId,omitempty"`
+	Name     string         `json:"name"`
+	Start    int64          `json:"startTimeUnixNano"`
+	End      int64          `json:"endTimeUnixNano"`
+	Duration string         `json:"duration"`
+	Attrs    map[string]any `json:"attributes,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+func NewJSON(w io.Writer, service string) *JSON {
+	return &JSON{service: service, w: w}
+}
+
+// Open exports to stdout for "stdout" and appends to the file otherwise.
+func Open(target, service string) (*JSON, error) {
+	if target == "stdout" {
+		return NewJSON(os.Stdout, service), nil
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	e := NewJSON(file, service)
+	e.closer = file
+	return e, nil
+}
+
+func (e *JSON) Export(s *Span) {
+	s.mu.Lock()
+	l := line{
+		Service:  e.service,
+		Trace:    s.Context.Trace.String(),
+		Span:     s.Context.Span.String(),
+		Name:     s.Name,
+		Start:    s.Start.UnixNano(),
+		End:      s.End.UnixNano(),
+		Duration: s.End.Sub(s.Start).String(),
+		Attrs:    s.Attrs,
+		Error:    s.Error,
+	}
+	if s.Parent != (SpanID{}) {
+		l.Parent = s.Parent.String()
+	}
+	encoded, err := json.Marshal(l)
+	s.mu.Unlock()
+	if err != nil {
+		slog.Error("encode span", "name", l.Name, "error", err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(append(encoded, '\n')); err != nil {
+		slog.Error("export span", "name", l.Name, "error", err)
+	}
+}
+
+func (e *JSON) Close(ctx context.Context) error {
+	SetExporter(nil)
+	if e.closer == nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.closer.Close()
+}
@@ -0,0 +1,74 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+)
+
+const Header = "Traceparent"
+
+// Inject passes the current span to the next hop.
+func Inject(ctx context.Context, h http.Header) {
+	if c := FromContext(ctx); c.Valid() {
+		h.Set(Header, c.String())
+	}
+}
+
+// Extract reads the span of the previous hop, an invalid header starts a
+// new trace.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	c, err := Parse(h.Get(Header))
+	if err != nil {
+		return ctx
+	}
+	return WithRemote(ctx, c)
+}
+
+// Wrap starts a span for every request named by the pattern the mux
+// matched.
+func Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(Extract(r.Context(), r.Header), r.Method+" "+r.URL.Path)
+		defer span.Finish()
+
+		writer := &statusResponse{ResponseWriter: w, code: http.StatusOK}
+		r = r.WithContext(ctx)
+		h.ServeHTTP(writer, r)
+
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+		}
+		span.Set("http.method", r.Method)
+		span.Set("http.target", r.URL.RequestURI())
+		span.Set("http.status_code", writer.code)
+	})
+}
+
+type statusResponse struct {
+	http.ResponseWriter
+	code    int
+	written bool
+}
+
+func (s *statusResponse) WriteHeader(code int) {
+	if !s.written {
+		s.code = code
+		s.written = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusResponse) Write(p []byte) (int, error) {
+	s.written = true
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusResponse) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusResponse) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
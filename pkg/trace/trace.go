@@ -0,0 +1,187 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrTraceparent = errors.New("invalid traceparent")
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext is what crosses process boundaries in the traceparent header.
+type SpanContext struct {
+	Trace   TraceID
+	Span    SpanID
+	Sampled bool
+}
+
+func (c SpanContext) Valid() bool {
+	return c.Trace != TraceID{} && c.Span != SpanID{}
+}
+
+// String formats the context as a W3C traceparent value.
+func (c SpanContext) String() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return "00-" + c.Trace.String() + "-" + c.Span.String() + "-" + flags
+}
+
+// Parse reads a W3C traceparent value. Unknown versions are read as the
+// first one, as the spec asks.
+func Parse(traceparent string) (SpanContext, error) {
+	fields := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" || (fields[0] == "00" && len(fields) != 4) {
+		return SpanContext{}, fmt.Errorf("%q: %w", traceparent, ErrTraceparent)
+	}
+
+	c := SpanContext{}
+	flags := make([]byte, 1)
+	if err := decode(c.Trace[:], fields[1]); err != nil {
+		return SpanContext{}, fmt.Errorf("trace id: %w", err)
+	}
+	if err := decode(c.Span[:], fields[2]); err != nil {
+		return SpanContext{}, fmt.Errorf("span id: %w", err)
+	}
+	if err := decode(flags, fields[3]); err != nil {
+		return SpanContext{}, fmt.Errorf("flags: %w", err)
+	}
+	if !c.Valid() {
+		return SpanContext{}, fmt.Errorf("zero ids: %w", ErrTraceparent)
+	}
+	c.Sampled = flags[0]&1 == 1
+
+	return c, nil
+}
+
+func decode(dst []byte, field string) error {
+	if len(field) != hex.EncodedLen(len(dst)) || strings.ToLower(field) != field {
+		return fmt.Errorf("%q: %w", field, ErrTraceparent)
+	}
+	if _, err := hex.Decode(dst, []byte(field)); err != nil {
+		return fmt.Errorf("%q: %w", field, ErrTraceparent)
+	}
+	return nil
+}
+
+// Span is a timed operation. Spans are exported when they end.
+type Span struct {
+	Name    string
+	Context SpanContext
+	Parent  SpanID
+	Start   time.Time
+	End     time.Time
+	Attrs   map[string]any
+	Error   string
+
+	mu    sync.Mutex
+	ended bool
+}
+
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Name = name
+}
+
+func (s *Span) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attrs[key] = value
+}
+
+// Fail records the error, a nil error is ignored.
+func (s *Span) Fail(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// Finish ends the span and exports it once.
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if exporter := current.Load(); exporter != nil && s.Context.Sampled {
+		(*exporter).Export(s)
+	}
+}
+
+// Exporter receives ended spans.
+type Exporter interface {
+	Export(s *Span)
+}
+
+var current atomic.Pointer[Exporter]
+
+// SetExporter sets where spans go, spans are only propagated while it is
+// not set.
+func SetExporter(e Exporter) {
+	if e == nil {
+		current.Store(nil)
+		return
+	}
+	current.Store(&e)
+}
+
+type spanKey struct{}
+
+// Start begins a span that is a child of the span or remote context in ctx,
+// or the root of a new trace.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	s := &Span{
+		Name:  name,
+		Start: time.Now(),
+		Attrs: make(map[string]any),
+	}
+
+	parent := FromContext(ctx)
+	if parent.Valid() {
+		s.Context.Trace = parent.Trace
+		s.Context.Sampled = parent.Sampled
+		s.Parent = parent.Span
+	} else {
+		_, _ = rand.Read(s.Context.Trace[:])
+		s.Context.Sampled = true
+	}
+	_, _ = rand.Read(s.Context.Span[:])
+
+	return context.WithValue(ctx, spanKey{}, s.Context), s
+}
+
+// FromContext returns the context of the current span, which is zero when
+// there is none.
+func FromContext(ctx context.Context) SpanContext {
+	c, _ := ctx.Value(spanKey{}).(SpanContext)
+	return c
+}
+
+// WithRemote makes the span context received from another process the
+// parent of spans started from the returned context.
+func WithRemote(ctx context.Context, c SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, c)
+}
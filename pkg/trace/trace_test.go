@@ -0,0 +1,114 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	c, err := Parse(value)
+	require.NoError(t, err)
+	assert.True(t, c.Sampled)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", c.Trace.String())
+	assert.Equal(t, "00f067aa0ba902b7", c.Span.String())
+	assert.Equal(t, value, c.String())
+
+	c, err = Parse("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+	require.NoError(t, err)
+	assert.False(t, c.Sampled)
+
+	for _, invalid := range []string{
+		"",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		_, err := Parse(invalid)
+		assert.ErrorIs(t, err, ErrTraceparent, invalid)
+	}
+}
+
+type spans []*Span
+
+func (s *spans) Export(span *Span) { *s = append(*s, span) }
+
+func TestStart(t *testing.T) {
+	exported := &spans{}
+	SetExporter(exported)
+	defer SetExporter(nil)
+
+	ctx, root := Start(context.Background(), "root")
+	_, child := Start(ctx, "child")
+	child.Fail(errors.New("boom"))
+	child.Finish()
+	child.Finish()
+	root.Finish()
+
+	require.Len(t, *exported, 2)
+	assert.Equal(t, root.Context.Trace, child.Context.Trace)
+	assert.Equal(t, root.Context.Span, child.Parent)
+	assert.NotEqual(t, root.Context.Span, child.Context.Span)
+	assert.Equal(t, SpanID{}, root.Parent)
+	assert.Equal(t, "boom", (*exported)[0].Error)
+}
+
+func TestPropagation(t *testing.T) {
+	exported := &spans{}
+	SetExporter(exported)
+	defer SetExporter(nil)
+
+	m := http.NewServeMux()
+	m.HandleFunc("POST /parts/{name}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "write")
+		span.Finish()
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	ctx, client := Start(context.Background(), "save")
+	req := httptest.NewRequest(http.MethodPost, "/parts/a", nil)
+	Inject(ctx, req.Header)
+	Wrap(m).ServeHTTP(httptest.NewRecorder(), req)
+	client.Finish()
+
+	require.Len(t, *exported, 3)
+	write, server := (*exported)[0], (*exported)[1]
+	assert.Equal(t, "POST /parts/{name}", server.Name)
+	assert.Equal(t, http.StatusCreated, server.Attrs["http.status_code"])
+	assert.Equal(t, client.Context.Span, server.Parent)
+	assert.Equal(t, server.Context.Span, write.Parent)
+	assert.Equal(t, client.Context.Trace, write.Context.Trace)
+}
+
+func TestJSON(t *testing.T) {
+	b := &bytes.Buffer{}
+	SetExporter(NewJSON(b, "balancer"))
+	defer SetExporter(nil)
+
+	_, span := Start(context.Background(), "hash")
+	span.Set("size", 10)
+	span.Finish()
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 1)
+	decoded := map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &decoded))
+	assert.Equal(t, "balancer", decoded["service"])
+	assert.Equal(t, "hash", decoded["name"])
+	assert.Equal(t, span.Context.Trace.String(), decoded["traceId"])
+	assert.NotContains(t, decoded, "parentSpanId")
+	assert.Equal(t, map[string]any{"size": float64(10)}, decoded["attributes"])
+}
@@ -0,0 +1,37 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Probe requests the path from the server listening on the address, an
+// unspecified host is replaced with the loopback one. It lets images without
+// a shell run healthchecks with their own binary.
+func Probe(addr, path string, timeout time.Duration) (e error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("split address: %w", err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	client := http.Client{Timeout: timeout}
+	res, err := client.Get(fmt.Sprintf("http://%s%s", net.JoinHostPort(host, port), path))
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer func() {
+		e = errors.Join(e, res.Body.Close())
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("error code %d", res.StatusCode)
+	}
+
+	return nil
+}
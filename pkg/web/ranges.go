@@ -0,0 +1,101 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrRange         = errors.New("invalid range")
+	ErrUnsatisfiable = errors.New("range not satisfiable")
+)
+
+// Range is a byte range of a resource.
+type Range struct {
+	Start  int
+	Length int
+}
+
+// ContentRange formats the range for the Content-Range header.
+func (r Range) ContentRange(size int) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange reads a Range header for a resource of the size. Ranges past
+// the end are dropped and ErrUnsatisfiable is returned when none is left.
+// Malformed headers and ranges adding up to more than the resource return
+// ErrRange, they are meant to be ignored by serving the whole resource.
+func ParseRange(header string, size int) ([]Range, error) {
+	unit, set, ok := strings.Cut(header, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, fmt.Errorf("%q: %w", header, ErrRange)
+	}
+
+	ranges := make([]Range, 0)
+	total := 0
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		r, ok, err := parseSpec(spec, size)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", spec, err)
+		}
+		if !ok {
+			continue
+		}
+		ranges = append(ranges, r)
+		total += r.Length
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("%q of %d bytes: %w", header, size, ErrUnsatisfiable)
+	}
+	if total > size {
+		return nil, fmt.Errorf("%q asks %d of %d bytes: %w", header, total, size, ErrRange)
+	}
+
+	return ranges, nil
+}
+
+// parseSpec reads a single range, ok is false when it starts past the end.
+func parseSpec(spec string, size int) (r Range, ok bool, e error) {
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return Range{}, false, ErrRange
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+	if first == "" {
+		suffix, err := strconv.Atoi(last)
+		if err != nil || suffix < 0 {
+			return Range{}, false, ErrRange
+		}
+		if suffix == 0 || size == 0 {
+			return Range{}, false, nil
+		}
+		suffix = min(suffix, size)
+		return Range{Start: size - suffix, Length: suffix}, true, nil
+	}
+
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 {
+		return Range{}, false, ErrRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.Atoi(last)
+		if err != nil || end < start {
+			return Range{}, false, ErrRange
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return Range{}, false, nil
+	}
+
+	return Range{Start: start, Length: end - start + 1}, true, nil
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		ranges []Range
+	}{
+		{"bytes=0-499", []Range{{0, 500}}},
+		{"bytes=500-999", []Range{{500, 500}}},
+		{"bytes=-500", []Range{{9500, 500}}},
+		{"bytes=9500-", []Range{{9500, 500}}},
+		{"bytes=9500-20000", []Range{{9500, 500}}},
+		{"bytes=-20000", []Range{{0, 10000}}},
+		{"bytes=0-0, -1", []Range{{0, 1}, {9999, 1}}},
+		{"bytes= 0-9 ,, 20-29", []Range{{0, 10}, {20, 10}}},
+		{"bytes=0-9,20000-", []Range{{0, 10}}},
+	}
+	for _, test := range tests {
+		ranges, err := ParseRange(test.header, 10000)
+		require.NoError(t, err, test.header)
+		assert.Equal(t, test.ranges, ranges, test.header)
+	}
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"0-10",
+		"items=0-10",
+		"bytes=a-10",
+		"bytes=10-5",
+		"bytes=10",
+		"bytes=--5",
+		"bytes=0-,0-",
+	} {
+		_, err := ParseRange(header, 100)
+		assert.ErrorIs(t, err, ErrRange, header)
+	}
+
+	for _, header := range []string{
+		"bytes=100-",
+		"bytes=200-300",
+		"bytes=-0",
+		"bytes=",
+	} {
+		_, err := ParseRange(header, 100)
+		assert.ErrorIs(t, err, ErrUnsatisfiable, header)
+	}
+
+	_, err := ParseRange("bytes=-5", 0)
+	assert.ErrorIs(t, err, ErrUnsatisfiable)
+}
+
+func TestContentRange(t *testing.T) {
+	assert.Equal(t, "bytes 0-499/1000", Range{Start: 0, Length: 500}.ContentRange(1000))
+}